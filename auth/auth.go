@@ -0,0 +1,129 @@
+// Package auth implements [proto.Authenticator]s.
+//
+//spellchecker:words auth
+package auth
+
+//spellchecker:words crypto subtle errors http strings github process over websocket proto
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Bearer authenticates requests using a bearer token in the Authorization header.
+type Bearer struct {
+	// Realm is sent to clients that fail to authenticate.
+	Realm string
+
+	// QueryParameter, if non-empty, is the name of a query parameter that may hold the token instead of the header.
+	// This is useful for websocket connections from browsers, which can not set custom headers.
+	QueryParameter string
+
+	// Verify returns the principal the given token belongs to.
+	// If the token is not valid, it returns an error.
+	Verify func(r *http.Request, token string) (*proto.Principal, error)
+}
+
+var (
+	errNoToken       = errors.New("no bearer token provided")
+	errNoCredentials = errors.New("no credentials provided")
+)
+
+// Authenticate implements [proto.Authenticator].
+func (bearer *Bearer) Authenticate(r *http.Request) (*proto.Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && bearer.QueryParameter != "" {
+		token = r.URL.Query().Get(bearer.QueryParameter)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("%w: %w", proto.ErrUnauthenticated, errNoToken)
+	}
+	return verify(bearer.Verify(r, token))
+}
+
+// Challenge implements [proto.Challenger].
+func (bearer *Bearer) Challenge() string {
+	return challenge("Bearer", bearer.Realm)
+}
+
+// Basic authenticates requests using HTTP basic authentication.
+type Basic struct {
+	// Realm is sent to clients that fail to authenticate.
+	Realm string
+
+	// Verify returns the principal the given credentials belong to.
+	// If the credentials are not valid, it returns an error.
+	Verify func(r *http.Request, username, password string) (*proto.Principal, error)
+}
+
+// Authenticate implements [proto.Authenticator].
+func (basic *Basic) Authenticate(r *http.Request) (*proto.Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, fmt.Errorf("%w: %w", proto.ErrUnauthenticated, errNoCredentials)
+	}
+	return verify(basic.Verify(r, username, password))
+}
+
+// Challenge implements [proto.Challenger].
+func (basic *Basic) Challenge() string {
+	return challenge("Basic", basic.Realm)
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// verify checks the result of a Verify function.
+func verify(principal *proto.Principal, err error) (*proto.Principal, error) {
+	if err != nil {
+		if errors.Is(err, proto.ErrUnauthenticated) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", proto.ErrUnauthenticated, err)
+	}
+	if principal == nil {
+		return nil, fmt.Errorf("%w: %w", proto.ErrUnauthenticated, errInvalidCredentials)
+	}
+	return principal, nil
+}
+
+// challenge formats a challenge for the given scheme and realm.
+func challenge(scheme, realm string) string {
+	if realm == "" {
+		return scheme
+	}
+	return scheme + ` realm="` + strings.ReplaceAll(realm, `"`, `\"`) + `"`
+}
+
+// Tokens returns a function to be used as [Bearer.Verify] that accepts a fixed set of tokens.
+// tokens maps each token to the name of the principal it belongs to.
+func Tokens(tokens map[string]string) func(r *http.Request, token string) (*proto.Principal, error) {
+	return func(r *http.Request, token string) (*proto.Principal, error) {
+		// compare against every token to not leak timing information
+		var name string
+		for candidate, principal := range tokens {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+				name = principal
+			}
+		}
+		if name == "" {
+			return nil, errInvalidCredentials
+		}
+		return &proto.Principal{Name: name}, nil
+	}
+}
+
+// Passwords returns a function to be used as [Basic.Verify] that accepts a fixed set of users.
+// passwords maps each username to its password.
+func Passwords(passwords map[string]string) func(r *http.Request, username, password string) (*proto.Principal, error) {
+	return func(r *http.Request, username, password string) (*proto.Principal, error) {
+		want, ok := passwords[username]
+		if subtle.ConstantTimeCompare([]byte(want), []byte(password)) != 1 || !ok {
+			return nil, errInvalidCredentials
+		}
+		return &proto.Principal{Name: username}, nil
+	}
+}
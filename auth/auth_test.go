@@ -0,0 +1,96 @@
+//spellchecker:words auth
+package auth_test
+
+//spellchecker:words errors http httptest testing github process over websocket auth proto
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket/auth"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+func TestBearer(t *testing.T) {
+	t.Parallel()
+
+	bearer := &auth.Bearer{
+		Realm:          "test",
+		QueryParameter: "access_token",
+		Verify:         auth.Tokens(map[string]string{"secret": "alice"}),
+	}
+
+	for _, tt := range []struct {
+		name   string
+		header string
+		url    string
+		want   string // name of principal, empty if unauthenticated
+	}{
+		{"header", "Bearer secret", "/", "alice"},
+		{"query", "", "/?access_token=secret", "alice"},
+		{"wrong token", "Bearer wrong", "/", ""},
+		{"no token", "", "/", ""},
+		{"other scheme", "Basic c2VjcmV0", "/", ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+
+		principal, err := bearer.Authenticate(r)
+		if tt.want == "" {
+			if !errors.Is(err, proto.ErrUnauthenticated) {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, proto.ErrUnauthenticated)
+			}
+			continue
+		}
+		if err != nil || principal.Name != tt.want {
+			t.Errorf("%s: got principal %v and error %v, want %q", tt.name, principal, err, tt.want)
+		}
+	}
+
+	if got, want := bearer.Challenge(), `Bearer realm="test"`; got != want {
+		t.Errorf("got challenge %q, want %q", got, want)
+	}
+}
+
+func TestBasic(t *testing.T) {
+	t.Parallel()
+
+	basic := &auth.Basic{
+		Verify: auth.Passwords(map[string]string{"alice": "password"}),
+	}
+
+	for _, tt := range []struct {
+		name               string
+		username, password string
+		want               bool
+	}{
+		{"valid", "alice", "password", true},
+		{"wrong password", "alice", "wrong", false},
+		{"unknown user", "bob", "password", false},
+		{"no password", "bob", "", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(tt.username, tt.password)
+
+		principal, err := basic.Authenticate(r)
+		if !tt.want {
+			if !errors.Is(err, proto.ErrUnauthenticated) {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, proto.ErrUnauthenticated)
+			}
+			continue
+		}
+		if err != nil || principal.Name != tt.username {
+			t.Errorf("%s: got principal %v and error %v, want %q", tt.name, principal, err, tt.username)
+		}
+	}
+
+	if _, err := basic.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, proto.ErrUnauthenticated) {
+		t.Errorf("got error %v, want %v", err, proto.ErrUnauthenticated)
+	}
+	if got, want := basic.Challenge(), "Basic"; got != want {
+		t.Errorf("got challenge %q, want %q", got, want)
+	}
+}
@@ -13,6 +13,7 @@ export default class RestSession implements Session {
 
   #connected = false
   #id: string | null = null
+  #secret: string | null = null
 
   async connect (): Promise<void> {
     if (this.#connected) {
@@ -20,11 +21,16 @@ export default class RestSession implements Session {
     }
     this.#connected = true
 
-    const id = await this.#rest('/new', this.call)
+    const { data: id, headers } = await this.#request('/new', this.call)
     if (typeof id !== 'string') {
       throw new Error('did not receive an id back')
     }
     this.#id = id
+
+    const secret = headers['x-session-secret']
+    if (typeof secret === 'string' && secret !== '') {
+      this.#secret = secret
+    }
   }
 
   readonly #result = new Lazy<WaitResult>()
@@ -81,16 +87,25 @@ export default class RestSession implements Session {
 
   /** sends a get request if data is not provided, and a post request otherwise */
   async #rest (path: string, data?: any): Promise<any> {
+    return (await this.#request(path, data)).data
+  }
+
+  /** like #rest, but returns the entire response */
+  async #request (path: string, data?: any): Promise<{ data: any, headers: Record<string, unknown> }> {
     const url = this.#buildURL(path)
 
-    const { headers } = this.remote
-    const config = (typeof headers !== 'undefined') ? { headers } : undefined
+    // pass along the secret of the session, if any
+    const headers: Record<string, string> = { ...this.remote.headers }
+    if (this.#secret !== null) {
+      headers['X-Session-Secret'] = this.#secret
+    }
+    const config = { headers }
 
     const res = await ((typeof data !== 'undefined') ? axios.post(url, data, config) : axios.get(url, config))
     if (res.status !== 200) {
       throw new Error('received invalid status code')
     }
-    return res.data
+    return { data: res.data, headers: res.headers }
   }
 
   /** builds the URL for the client to connect to the specified path */
@@ -14,6 +14,7 @@ export interface Remote {
 export interface CallSpec {
   call: string
   params: string[]
+  priority?: number // priority while waiting for other processes to finish
 }
 
 
@@ -39,11 +40,18 @@ interface ResultPending {
 export interface WaitResult {
   result: Result
   buffer?: string
+  stderr?: string
 }
 
 export interface Status {
   result: Result | ResultPending 
   buffer?: string
+  cursor?: number
+  dropped?: number
+  stderr?: string
+  stderrCursor?: number
+  stderrDropped?: number
+  queued?: number // position in the queue while waiting to start
 }
 
 export function isStatus(value: unknown): value is Status {
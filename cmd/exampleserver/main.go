@@ -1,7 +1,7 @@
 //spellchecker:words main
 package main
 
-//spellchecker:words context http signal github process over websocket proto
+//spellchecker:words context http signal github process over websocket registry
 import (
 	"context"
 	"fmt"
@@ -13,7 +13,7 @@ import (
 	"os/signal"
 
 	"github.com/FAU-CDI/process_over_websocket"
-	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/registry"
 )
 
 var bind_addr string = "0.0.0.0:3000"
@@ -26,28 +26,22 @@ func main() {
 	// create a new process_over_websocket Server
 	var server process_over_websocket.Server
 	server.Options.RESTOptions.OpenAPIServerDescription = "Process Over Websocket Testing Server"
-	server.Handler = proto.HandlerFunc(func(r *http.Request, name string, args ...string) (proto.Process, error) {
-		log.Printf("got request for %s %v", name, args)
 
-		// must be the echo handler
-		if name != "echo" {
-			return nil, proto.ErrHandlerUnknownProcess
-		}
-
-		// return the error handler
-		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
-			// log that we are doing something
-			log.Println("starting new process")
-			defer log.Println("process exited")
+	// register the processes the server provides
+	var processes registry.Registry
+	processes.RegisterFunc("echo", registry.Info{Description: "echoes input back to output and returns the parameters", Variadic: true, Input: true}, func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+		// log that we are doing something
+		log.Printf("starting new process with %v", args)
+		defer log.Println("process exited")
 
-			// copy over the content
-			if _, err := io.Copy(output, input); err != nil {
-				return nil, fmt.Errorf("failed to copy: %w", err)
-			}
+		// copy over the content
+		if _, err := io.Copy(output, input); err != nil {
+			return nil, fmt.Errorf("failed to copy: %w", err)
+		}
 
-			return args, context.Cause(ctx)
-		}), nil
+		return args, context.Cause(ctx)
 	})
+	server.Handler = &processes
 
 	// start listening
 	listen, err := net.Listen("tcp", bind_addr) //#nosec G102 -- bind_addr is a parameter
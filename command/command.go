@@ -0,0 +1,240 @@
+// Package command implements [Command].
+//
+//spellchecker:words command
+package command
+
+//spellchecker:words context errors exec strconv strings syscall time github process over websocket proto
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Command is a [proto.Process] that runs an external command.
+//
+// The input of the process is passed to the standard input of the command.
+// Standard output of the command is written to the output of the process.
+// Standard error is written to the error output if the server supports it (see [proto.StderrProcess]), and to the output otherwise.
+//
+// When the context of the process is cancelled, the command is sent SIGTERM.
+// If it does not exit within the grace period, it is killed.
+//
+// If the command exits with code zero, the process returns a [Result].
+// Otherwise, it returns an [*ExitError] holding the result, which is sent to clients as the details of the rejected result.
+// This includes commands stopped because the context was cancelled, in which case the error wraps the cause of the cancellation.
+type Command struct {
+	// Argv is a template for the command line to run.
+	// The first element is the program, which is looked up using [exec.LookPath] if it contains no path separators.
+	//
+	// Each element is expanded using the parameters of the call:
+	//
+	//   - "$1", "$2", ..., "${10}", ... are replaced by the respective parameter
+	//   - "$#" is replaced by the number of parameters
+	//   - "$*" is replaced by all parameters, separated by spaces
+	//   - "$$" is replaced by a literal "$"
+	//
+	// An element consisting of exactly "$@" is replaced by all parameters as separate elements.
+	// Any other variable is an error.
+	Argv []string
+
+	// Env holds additional environment variables of the form "key=value".
+	// They are expanded like Argv and appended to the environment of the server.
+	Env []string
+
+	// Dir is the working directory of the command.
+	// If empty, uses the working directory of the server.
+	Dir string
+
+	// GracePeriod is the time given to the command to exit after receiving SIGTERM.
+	// Defaults to [DefaultGracePeriod].
+	GracePeriod time.Duration
+
+	// IgnoreExitCode causes the process to return a [Result] even when the command exits with a non-zero code.
+	IgnoreExitCode bool
+}
+
+// DefaultGracePeriod is the default grace period for commands.
+const DefaultGracePeriod = 10 * time.Second
+
+// Result is the result of running a command.
+type Result struct {
+	// ExitCode is the exit code of the command.
+	ExitCode int `json:"exitCode"`
+
+	// Duration is the amount of time the command was running.
+	Duration time.Duration `json:"duration"`
+}
+
+// CodeExitStatus is the code of an [*ExitError].
+const CodeExitStatus proto.ErrorCode = "exit_status"
+
+// ExitError is returned when the command exits with a non-zero exit code, or is stopped because the context was cancelled.
+//
+// It implements [proto.CodedError], using the result as its details.
+// The code is [CodeExitStatus], or the code of Cause if it is non-nil.
+type ExitError struct {
+	Result Result
+
+	// Cause is the cause of the cancellation of the context, or nil if the command exited on its own.
+	Cause error
+}
+
+func (ee *ExitError) Error() string {
+	if ee.Cause != nil {
+		return ee.Cause.Error()
+	}
+	return "exit status " + strconv.Itoa(ee.Result.ExitCode)
+}
+
+// Unwrap returns the cause of the cancellation, if any.
+func (ee *ExitError) Unwrap() error {
+	return ee.Cause
+}
+
+func (ee *ExitError) ErrorCode() proto.ErrorCode {
+	if ee.Cause != nil {
+		code, _ := proto.CodeOf(ee.Cause)
+		return code
+	}
+	return CodeExitStatus
+}
+
+func (ee *ExitError) ErrorDetails() any {
+	return ee.Result
+}
+
+var errNoArgv = errors.New("command: empty command line")
+
+// Do implements [proto.Process].
+func (command *Command) Do(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+	return command.DoStderr(ctx, input, output, output, args...)
+}
+
+// DoStderr implements [proto.StderrProcess].
+func (command *Command) DoStderr(ctx context.Context, input io.Reader, output, stderr io.Writer, args ...string) (any, error) {
+	argv, err := Expand(command.Argv, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(argv) == 0 {
+		return nil, errNoArgv
+	}
+	env, err := Expand(command.Env, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...) // #nosec G204 -- running arbitrary commands is the point
+	cmd.Dir = command.Dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = output
+	cmd.Stderr = stderr
+
+	// terminate gracefully, and kill after the grace period
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = command.GracePeriod
+	if cmd.WaitDelay <= 0 {
+		cmd.WaitDelay = DefaultGracePeriod
+	}
+
+	// pass the input manually, so that waiting does not depend on the input being closed.
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create input pipe: %w", err)
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	go func() {
+		defer func() { _ = stdin.Close() }()
+		_, _ = io.Copy(stdin, input)
+	}()
+
+	err = cmd.Wait()
+	result := Result{
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Duration: time.Since(start),
+	}
+
+	// context was cancelled
+	if cause := context.Cause(ctx); cause != nil {
+		return nil, &ExitError{Result: result, Cause: cause}
+	}
+
+	// command did not exit properly
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("failed to wait for command: %w", err)
+	}
+
+	if result.ExitCode != 0 && !command.IgnoreExitCode {
+		return nil, &ExitError{Result: result}
+	}
+	return result, nil
+}
+
+var (
+	errUnknownVariable = errors.New("unknown variable")
+	errMissingParam    = errors.New("missing parameter")
+)
+
+// Expand expands the given templates using the given parameters.
+// See the Argv field of [Command] for the template syntax.
+//
+// If a template references a parameter that was not provided, returns an error wrapping [proto.ErrHandlerInvalidArgs].
+func Expand(templates []string, params ...string) ([]string, error) {
+	expanded := make([]string, 0, len(templates))
+	for _, template := range templates {
+		if template == "$@" || template == "${@}" {
+			expanded = append(expanded, params...)
+			continue
+		}
+
+		var err error
+		value := os.Expand(template, func(name string) string {
+			switch name {
+			case "$":
+				return "$"
+			case "#":
+				return strconv.Itoa(len(params))
+			case "*", "@":
+				return strings.Join(params, " ")
+			}
+
+			index, e := strconv.Atoi(name)
+			if e != nil || index < 1 {
+				err = errors.Join(err, fmt.Errorf("%w %q in %q", errUnknownVariable, name, template))
+				return ""
+			}
+			if index > len(params) {
+				err = errors.Join(err, fmt.Errorf("%w: %w %d", proto.ErrHandlerInvalidArgs, errMissingParam, index))
+				return ""
+			}
+			return params[index-1]
+		})
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, value)
+	}
+	return expanded, nil
+}
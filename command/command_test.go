@@ -0,0 +1,168 @@
+//go:build unix
+
+//spellchecker:words command
+package command_test
+
+//spellchecker:words context encoding json errors slices strings testing time github process over websocket command proto
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/command"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+func TestExpand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		templates []string
+		params    []string
+		want      []string
+		wantErr   error
+	}{
+		{name: "literal", templates: []string{"ls", "-l"}, want: []string{"ls", "-l"}},
+		{name: "positional", templates: []string{"drush", "--uri=$1", "${2}"}, params: []string{"a", "b"}, want: []string{"drush", "--uri=a", "b"}},
+		{name: "splice", templates: []string{"echo", "$@", "end"}, params: []string{"a", "b"}, want: []string{"echo", "a", "b", "end"}},
+		{name: "joined", templates: []string{"$# params: $*"}, params: []string{"a", "b"}, want: []string{"2 params: a b"}},
+		{name: "dollar", templates: []string{"$$1"}, params: []string{"a"}, want: []string{"$1"}},
+		{name: "missing", templates: []string{"$2"}, params: []string{"a"}, wantErr: proto.ErrHandlerInvalidArgs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := command.Expand(tt.templates, tt.params...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_Do(t *testing.T) {
+	t.Parallel()
+
+	cmd := command.Command{Argv: []string{"sh", "-c", `cat; echo "$1"`, "sh", "$1"}}
+
+	var output strings.Builder
+	value, err := cmd.Do(t.Context(), strings.NewReader("input\n"), &output, "param")
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if got := output.String(); got != "input\nparam\n" {
+		t.Errorf("got output %q, want %q", got, "input\nparam\n")
+	}
+	if result := value.(command.Result); result.ExitCode != 0 {
+		t.Errorf("got exit code %d, want 0", result.ExitCode)
+	}
+}
+
+func TestCommand_DoStderr(t *testing.T) {
+	t.Parallel()
+
+	cmd := command.Command{Argv: []string{"sh", "-c", "echo out; echo err >&2"}}
+
+	var output, stderr strings.Builder
+	if _, err := cmd.DoStderr(t.Context(), strings.NewReader(""), &output, &stderr); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if got := output.String(); got != "out\n" {
+		t.Errorf("got output %q, want %q", got, "out\n")
+	}
+	if got := stderr.String(); got != "err\n" {
+		t.Errorf("got stderr %q, want %q", got, "err\n")
+	}
+}
+
+func TestCommand_Do_exitCode(t *testing.T) {
+	t.Parallel()
+
+	cmd := command.Command{Argv: []string{"sh", "-c", "exit 3"}}
+
+	_, err := cmd.Do(t.Context(), strings.NewReader(""), &strings.Builder{})
+
+	var exitErr *command.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Result.ExitCode != 3 {
+		t.Errorf("got error %v, want exit code 3", err)
+	}
+
+	// clients receive the exit code and duration
+	data, err := (&proto.Result{Reason: fmt.Errorf("process returned error: %w", err)}).MarshalJSON()
+	if err != nil {
+		t.Fatalf("failed to marshal result: %v", err)
+	}
+	var result struct {
+		Status  string          `json:"status"`
+		Reason  string          `json:"reason"`
+		Code    proto.ErrorCode `json:"code"`
+		Details *command.Result `json:"details"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if result.Status != "rejected" || result.Code != command.CodeExitStatus || result.Details == nil || *result.Details != exitErr.Result {
+		t.Errorf("got result %s, want code %q and details %+v", data, command.CodeExitStatus, exitErr.Result)
+	}
+
+	cmd.IgnoreExitCode = true
+	value, err := cmd.Do(t.Context(), strings.NewReader(""), &strings.Builder{})
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if result := value.(command.Result); result.ExitCode != 3 {
+		t.Errorf("got exit code %d, want 3", result.ExitCode)
+	}
+}
+
+func TestCommand_Do_cancel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		argv []string
+	}{
+		{name: "terminate", argv: []string{"sleep", "60"}},
+		{name: "kill", argv: []string{"sh", "-c", "trap '' TERM; sleep 60"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := command.Command{Argv: tt.argv, GracePeriod: 100 * time.Millisecond}
+
+			ctx, cancel := context.WithCancelCause(t.Context())
+			time.AfterFunc(100*time.Millisecond, func() { cancel(proto.ErrCancelClientRequest) })
+
+			start := time.Now()
+			_, err := cmd.Do(ctx, strings.NewReader(""), &strings.Builder{})
+			if !errors.Is(err, proto.ErrCancelClientRequest) {
+				t.Errorf("got error %v, want %v", err, proto.ErrCancelClientRequest)
+			}
+
+			// the result is still reported as details
+			var exitErr *command.ExitError
+			if !errors.As(err, &exitErr) || exitErr.Result.Duration <= 0 {
+				t.Errorf("got error %v, want result", err)
+			}
+			if code, details := proto.CodeOf(err); code != proto.CodeClientRequest || details == nil {
+				t.Errorf("got code %q and details %v, want %q and result", code, details, proto.CodeClientRequest)
+			}
+			if took := time.Since(start); took > 5*time.Second {
+				t.Errorf("command took %v to be cancelled", took)
+			}
+		})
+	}
+}
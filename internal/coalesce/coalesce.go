@@ -0,0 +1,225 @@
+// Package coalesce implements [Coalescer].
+//
+//spellchecker:words coalesce
+package coalesce
+
+//spellchecker:words bytes sync time
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultSize is the default value for [Options.Size].
+const DefaultSize = 4096
+
+// Options configure a [Coalescer].
+type Options struct {
+	// Window is the maximal time output is held back before it is flushed.
+	// If it is not positive, output is not coalesced.
+	Window time.Duration
+
+	// Size is the number of buffered bytes after which output is flushed immediately.
+	// If it is not positive, [DefaultSize] is used.
+	Size int
+}
+
+// Enabled reports if these options enable coalescing.
+func (opt Options) Enabled() bool {
+	return opt.Window > 0
+}
+
+// Coalescer batches small writes to one or more writers into fewer, larger writes.
+//
+// Buffered output is flushed once it contains a newline or carriage return, once it exceeds the size threshold,
+// once the time window since the first buffered write has passed, or once the coalescer is closed.
+// Flushing only writes data up to the last newline or carriage return, unless all data is flushed.
+//
+// Output to different writers shares a single buffer, so the order of writes is preserved across writers.
+// Flushed output is written without holding the buffer, so a blocked writer only blocks writes that flush.
+//
+// Coalescer is safe for concurrent use.
+type Coalescer struct {
+	options Options
+
+	// m protects the fields below
+	m       sync.Mutex
+	buf     []byte
+	current *writer     // writer the buffered data belongs to
+	pending []chunk     // flushed data not yet written, oldest first
+	timer   *time.Timer // flushes once the window has passed, if any
+	err     error       // first error encountered by an asynchronous flush
+	closed  bool
+
+	// wm is held while writing pending data, so that it is written in order without holding m
+	wm sync.Mutex
+}
+
+// chunk is flushed data to be written to w.
+type chunk struct {
+	w    io.Writer
+	data []byte
+}
+
+// New creates a new coalescer with the given options.
+func New(options Options) *Coalescer {
+	if options.Size <= 0 {
+		options.Size = DefaultSize
+	}
+	return &Coalescer{options: options}
+}
+
+// Writer returns a writer that writes to w via this coalescer.
+// If coalescing is disabled, returns w.
+func (c *Coalescer) Writer(w io.Writer) io.Writer {
+	if !c.options.Enabled() {
+		return w
+	}
+	return &writer{c: c, w: w}
+}
+
+type writer struct {
+	c *Coalescer
+	w io.Writer
+}
+
+func (w *writer) Write(data []byte) (int, error) {
+	return w.c.write(w, data)
+}
+
+func (c *Coalescer) write(w *writer, data []byte) (int, error) {
+	flushed, err := c.buffer(w, data)
+	if err != nil {
+		return 0, err
+	}
+
+	// only wait for pending data to be written if we added to it
+	if flushed {
+		if err := c.drain(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// buffer adds data written to w to the buffer, flushing as needed.
+// It reports if any data was flushed.
+func (c *Coalescer) buffer(w *writer, data []byte) (flushed bool, err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.closed {
+		return false, io.ErrClosedPipe
+	}
+	if c.err != nil {
+		return false, c.err
+	}
+	pending := len(c.pending)
+
+	// the buffer belongs to a different writer
+	if c.current != w {
+		c.flush(len(c.buf))
+		c.current = w
+	}
+
+	c.buf = append(c.buf, data...)
+
+	// flush complete lines
+	if i := bytes.LastIndexAny(c.buf, "\n\r"); i >= 0 {
+		c.flush(i + 1)
+	}
+
+	// flush everything if we exceed the size
+	if len(c.buf) >= c.options.Size {
+		c.flush(len(c.buf))
+	}
+
+	// flush the remainder once the window has passed
+	if len(c.buf) > 0 && c.timer == nil {
+		c.timer = time.AfterFunc(c.options.Window, c.tick)
+	}
+	return len(c.pending) > pending, nil
+}
+
+// tick is called once the window has passed.
+func (c *Coalescer) tick() {
+	c.m.Lock()
+	c.timer = nil
+	c.flush(len(c.buf))
+	c.m.Unlock()
+
+	if err := c.drain(); err != nil {
+		c.m.Lock()
+		defer c.m.Unlock()
+
+		if c.err == nil {
+			c.err = err
+		}
+	}
+}
+
+// flush moves the first n bytes of the buffer to the pending data of the current writer.
+// They are written by the next call to drain.
+// c.m must be held.
+func (c *Coalescer) flush(n int) {
+	if n == 0 {
+		return
+	}
+
+	c.pending = append(c.pending, chunk{w: c.current.w, data: c.buf[:n]})
+	c.buf = append([]byte(nil), c.buf[n:]...)
+
+	// no need to wait for the window
+	if len(c.buf) == 0 && c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// drain writes all pending data to the respective writers, in the order it was flushed.
+// c.m must not be held, as writing may block.
+func (c *Coalescer) drain() error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+
+	for {
+		c.m.Lock()
+		if len(c.pending) == 0 {
+			c.m.Unlock()
+			return nil
+		}
+		next := c.pending[0]
+		c.pending = c.pending[1:]
+		c.m.Unlock()
+
+		if _, err := next.w.Write(next.data); err != nil {
+			return fmt.Errorf("failed to flush: %w", err)
+		}
+	}
+}
+
+// Flush writes all buffered output.
+func (c *Coalescer) Flush() error {
+	c.m.Lock()
+	c.flush(len(c.buf))
+	c.m.Unlock()
+
+	return c.drain()
+}
+
+// Close flushes all buffered output.
+// Any further writes return [io.ErrClosedPipe].
+func (c *Coalescer) Close() error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.flush(len(c.buf))
+	c.m.Unlock()
+
+	return c.drain()
+}
@@ -0,0 +1,184 @@
+//spellchecker:words coalesce
+package coalesce_test
+
+//spellchecker:words slices sync testing time github process over websocket internal coalesce
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/coalesce"
+)
+
+// recorder records all writes.
+type recorder struct {
+	m      sync.Mutex
+	writes []string
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.writes = append(r.writes, string(data))
+	return len(data), nil
+}
+
+func (r *recorder) Writes() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return slices.Clone(r.writes)
+}
+
+func write(t *testing.T, w interface{ Write([]byte) (int, error) }, data ...string) {
+	t.Helper()
+
+	for _, d := range data {
+		if _, err := w.Write([]byte(d)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+}
+
+func TestCoalescer_lines(t *testing.T) {
+	t.Parallel()
+
+	var rec recorder
+	c := coalesce.New(coalesce.Options{Window: time.Hour})
+
+	w := c.Writer(&rec)
+	write(t, w, "h", "e", "l", "l", "o", "\n", "wor", "ld\rpartial")
+
+	if got, want := rec.Writes(), []string{"hello\n", "world\r"}; !slices.Equal(got, want) {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+
+	// closing flushes the remainder
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if got, want := rec.Writes(), []string{"hello\n", "world\r", "partial"}; !slices.Equal(got, want) {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+
+	if _, err := w.Write([]byte("late")); err == nil {
+		t.Error("write after close succeeded")
+	}
+}
+
+func TestCoalescer_size(t *testing.T) {
+	t.Parallel()
+
+	var rec recorder
+	c := coalesce.New(coalesce.Options{Window: time.Hour, Size: 4})
+
+	write(t, c.Writer(&rec), "ab", "cd", "e")
+
+	if got, want := rec.Writes(), []string{"abcd"}; !slices.Equal(got, want) {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+}
+
+func TestCoalescer_window(t *testing.T) {
+	t.Parallel()
+
+	var rec recorder
+	c := coalesce.New(coalesce.Options{Window: 10 * time.Millisecond})
+
+	write(t, c.Writer(&rec), "a", "b", "c")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.Writes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := rec.Writes(), []string{"abc"}; !slices.Equal(got, want) {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+}
+
+func TestCoalescer_order(t *testing.T) {
+	t.Parallel()
+
+	var out, err recorder
+	c := coalesce.New(coalesce.Options{Window: time.Hour})
+
+	ow, ew := c.Writer(&out), c.Writer(&err)
+	write(t, ow, "a")
+	write(t, ew, "b")
+	write(t, ow, "c")
+
+	// switching writers flushes the buffered output of the previous one
+	if got, want := out.Writes(), []string{"a"}; !slices.Equal(got, want) {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	if got, want := err.Writes(), []string{"b"}; !slices.Equal(got, want) {
+		t.Errorf("got error output %q, want %q", got, want)
+	}
+}
+
+// blocker is a recorder whose writes block until release is closed.
+type blocker struct {
+	recorder
+	started chan struct{} // closed once the first write has started
+	release chan struct{}
+}
+
+func (b *blocker) Write(data []byte) (int, error) {
+	select {
+	case <-b.started:
+	default:
+		close(b.started)
+	}
+	<-b.release
+	return b.recorder.Write(data)
+}
+
+func TestCoalescer_blocked(t *testing.T) {
+	t.Parallel()
+
+	var out recorder
+	slow := &blocker{started: make(chan struct{}), release: make(chan struct{})}
+	c := coalesce.New(coalesce.Options{Window: time.Hour})
+
+	// a flush to a blocked writer
+	go func() { _, _ = c.Writer(slow).Write([]byte("line\n")) }()
+	<-slow.started
+
+	// does not block buffering output of another writer
+	buffered := make(chan struct{})
+	go func() {
+		defer close(buffered)
+		_, _ = c.Writer(&out).Write([]byte("partial"))
+	}()
+	select {
+	case <-buffered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked by a blocked flush")
+	}
+
+	// and output is written in order once it is released
+	close(slow.release)
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if got, want := slow.Writes(), []string{"line\n"}; !slices.Equal(got, want) {
+		t.Errorf("got blocked writes %q, want %q", got, want)
+	}
+	if got, want := out.Writes(), []string{"partial"}; !slices.Equal(got, want) {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+}
+
+func TestCoalescer_disabled(t *testing.T) {
+	t.Parallel()
+
+	var rec recorder
+	c := coalesce.New(coalesce.Options{})
+
+	write(t, c.Writer(&rec), "a", "b")
+	if got, want := rec.Writes(), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+}
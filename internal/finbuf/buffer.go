@@ -3,18 +3,21 @@
 //spellchecker:words finbuf
 package finbuf
 
-//spellchecker:words sync github pkglib ringbuffer status
+//spellchecker:words strings sync github pkglib status
 import (
 	"fmt"
+	"strings"
 	"sync"
 
-	"go.tkw01536.de/pkglib/ringbuffer"
 	"go.tkw01536.de/pkglib/status"
 )
 
 // FiniteBuffer is an [io.Writer] that contains a maximal number of lines.
 // Do not copy a non-zero LineBuffer.
 //
+// Each line written to the buffer is assigned a sequence number, starting at 1.
+// Once more than MaxLines lines have been written, the oldest lines are dropped.
+//
 // LineBuffer is safe for concurrent read and write access.
 type FiniteBuffer struct {
 	m   sync.RWMutex
@@ -23,7 +26,12 @@ type FiniteBuffer struct {
 	doInit sync.Once
 
 	MaxLines int
-	lines    *ringbuffer.RingBuffer[string]
+
+	lines []string // ring of at most MaxLines lines
+	start int      // index of the oldest line in lines
+	total uint64   // total number of lines written, i.e. the sequence number of the newest line
+
+	changed chan struct{} // closed and replaced whenever a new line is written
 }
 
 // init ensures that the FiniteBuffer is initialized.
@@ -32,7 +40,8 @@ func (fb *FiniteBuffer) init() {
 		fb.buf.Line = fb.line
 
 		// prepare the line array
-		fb.lines = ringbuffer.MakeRingBuffer[string](fb.MaxLines)
+		fb.lines = make([]string, 0, max(fb.MaxLines, 0))
+		fb.changed = make(chan struct{})
 	})
 }
 
@@ -44,8 +53,22 @@ func (fb *FiniteBuffer) line(line string) {
 	fb.m.Lock()
 	defer fb.m.Unlock()
 
+	fb.total++
+
+	// notify anyone waiting for changes
+	close(fb.changed)
+	fb.changed = make(chan struct{})
+
 	// todo: set max line length
-	fb.lines.Add(line)
+	switch {
+	case fb.MaxLines <= 0:
+		/* nothing to store */
+	case len(fb.lines) < fb.MaxLines:
+		fb.lines = append(fb.lines, line)
+	default:
+		fb.lines[fb.start] = line
+		fb.start = (fb.start + 1) % len(fb.lines)
+	}
 }
 
 func (fb *FiniteBuffer) Write(data []byte) (int, error) {
@@ -58,12 +81,68 @@ func (fb *FiniteBuffer) Write(data []byte) (int, error) {
 	return n, nil
 }
 
+// Restore replaces the content of the buffer with the given lines, the newest of which has sequence number total.
+// If there are more than MaxLines lines, only the newest ones are kept.
+func (fb *FiniteBuffer) Restore(lines []string, total uint64) {
+	fb.init()
+
+	fb.m.Lock()
+	defer fb.m.Unlock()
+
+	lines = lines[len(lines)-min(len(lines), max(fb.MaxLines, 0)):]
+	if uint64(len(lines)) > total {
+		lines = lines[uint64(len(lines))-total:]
+	}
+
+	fb.lines = append(fb.lines[:0], lines...)
+	fb.start = 0
+	fb.total = total
+
+	close(fb.changed)
+	fb.changed = make(chan struct{})
+}
+
 // String returns a copy of the lines contained in the buffer.
 func (fb *FiniteBuffer) String() string {
+	lines, _, _ := fb.Since(0)
+	return strings.Join(lines, "\n")
+}
+
+// Changed returns a channel that is closed once a new line is written to the buffer.
+func (fb *FiniteBuffer) Changed() <-chan struct{} {
 	fb.init()
 
 	fb.m.RLock()
 	defer fb.m.RUnlock()
 
-	return ringbuffer.Join(fb.lines, "\n")
+	return fb.changed
+}
+
+// Since returns a copy of the lines contained in the buffer with a sequence number greater than since.
+//
+// next is the sequence number of the newest line written, and can be passed to a subsequent call to only retrieve newer lines.
+// dropped is the number of lines newer than since that are no longer contained in the buffer.
+func (fb *FiniteBuffer) Since(since uint64) (lines []string, next uint64, dropped uint64) {
+	fb.init()
+
+	fb.m.RLock()
+	defer fb.m.RUnlock()
+
+	// sequence number of the oldest line still contained
+	oldest := fb.total - uint64(len(fb.lines)) + 1
+	if since+1 < oldest {
+		dropped = oldest - (since + 1)
+		since = oldest - 1
+	}
+
+	if since >= fb.total {
+		return []string{}, fb.total, dropped
+	}
+
+	count := fb.total - since
+	lines = make([]string, 0, count)
+	for i := uint64(len(fb.lines)) - count; i < uint64(len(fb.lines)); i++ {
+		lines = append(lines, fb.lines[(fb.start+int(i))%len(fb.lines)]) // #nosec G115 -- i < len(fb.lines)
+	}
+	return lines, fb.total, dropped
 }
@@ -51,3 +51,45 @@ func ExampleFiniteBuffer() {
 	// Output: 2
 	// 1
 }
+
+func ExampleFiniteBuffer_Since() {
+	var buffer finbuf.FiniteBuffer
+	buffer.MaxLines = 3
+
+	_, _ = buffer.Write([]byte("1\n2\n"))
+
+	// read all the lines, and remember where we were
+	lines, next, dropped := buffer.Since(0)
+	fmt.Println(lines, next, dropped)
+
+	// write some more lines, some of which are dropped
+	_, _ = buffer.Write([]byte("3\n4\n5\n6\n"))
+
+	// only newer lines are returned
+	lines, next, dropped = buffer.Since(next)
+	fmt.Println(lines, next, dropped)
+
+	// and nothing once we're up to date
+	lines, next, dropped = buffer.Since(next)
+	fmt.Println(lines, next, dropped)
+
+	// Output: [1 2] 2 0
+	// [4 5 6] 6 1
+	// [] 6 0
+}
+
+func ExampleFiniteBuffer_Restore() {
+	var buffer finbuf.FiniteBuffer
+	buffer.MaxLines = 2
+
+	// restore the last lines of a buffer that had 5 lines written to it
+	buffer.Restore([]string{"3", "4", "5"}, 5)
+	fmt.Println(buffer.Since(0))
+
+	// new lines continue after the restored ones
+	_, _ = buffer.Write([]byte("6\n"))
+	fmt.Println(buffer.Since(4))
+
+	// Output: [4 5] 5 3
+	// [5 6] 6 0
+}
@@ -0,0 +1,324 @@
+// Package history keeps a history of finished sessions.
+//
+//spellchecker:words history
+package history
+
+//spellchecker:words errors slices strconv strings sync time github process over websocket store
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/store"
+)
+
+// Options configure which finished sessions a [History] keeps.
+type Options struct {
+	// MaxEntries is the maximal number of sessions kept.
+	// Once exceeded, the sessions started first are removed.
+	// If it is not positive, the number of sessions is not limited.
+	MaxEntries int
+
+	// MaxAge is the time sessions are kept after they finish.
+	// If it is not positive, sessions are kept regardless of their age.
+	MaxAge time.Duration
+
+	// MaxLines is the number of lines of output and error output kept for each session; older lines are dropped.
+	// If it is zero, [DefaultMaxLines] is used; if it is negative, no output is kept.
+	MaxLines int
+
+	// Store, if non-nil, persists the history so that it survives restarts of the server.
+	// It should not be the store used for ongoing sessions.
+	Store store.Store
+}
+
+// DefaultMaxLines is the default for [Options.MaxLines].
+const DefaultMaxLines = 100
+
+// Enabled reports if these options keep any history.
+func (opts Options) Enabled() bool {
+	return opts.MaxEntries > 0 || opts.MaxAge > 0
+}
+
+// Statuses of finished sessions, see [Status].
+const (
+	StatusFulfilled = "fulfilled"
+	StatusRejected  = "rejected"
+)
+
+// Status returns the status of the result of record.
+// Records without a result are considered rejected.
+func Status(record *store.Record) string {
+	if record.Result == nil || record.Result.Reason != nil {
+		return StatusRejected
+	}
+	return StatusFulfilled
+}
+
+// History holds records of finished sessions, newest first.
+//
+// A nil History keeps no sessions.
+// History is safe for concurrent use.
+type History struct {
+	options Options
+
+	m       sync.Mutex
+	records []*store.Record // ordered by compare
+}
+
+// New creates a new history with the given options, restoring any records persisted in its store.
+// Records that can not be read are skipped.
+// If the options do not keep any history, returns nil.
+func New(options Options) *History {
+	if !options.Enabled() {
+		return nil
+	}
+	if options.MaxLines == 0 {
+		options.MaxLines = DefaultMaxLines
+	}
+
+	history := &History{options: options}
+	if options.Store != nil {
+		records, _ := options.Store.List()
+		for _, record := range records {
+			history.insert(record)
+		}
+		history.prune(time.Now())
+	}
+	return history
+}
+
+// compare orders records by the time they started, newest first.
+// Records that started at the same time are ordered by id.
+func compare(a, b *store.Record) int {
+	if c := b.Started.Compare(a.Started); c != 0 {
+		return c
+	}
+	return strings.Compare(b.ID, a.ID)
+}
+
+// Add adds a record of a finished session to the history, replacing any record with the same id.
+// Only the newest lines of output are kept, see [Options.MaxLines].
+//
+// The history keeps its own copy of record.
+func (history *History) Add(record *store.Record) {
+	if history == nil {
+		return
+	}
+
+	entry := *record
+	entry.Output = history.truncate(record.Output)
+	entry.Stderr = history.truncate(record.Stderr)
+	entry.SecretHash = slices.Clone(record.SecretHash)
+	entry.Call.Params = slices.Clone(record.Call.Params)
+	entry.Started = record.Started.Round(0) // compare wall clock times only, like those of restored records
+	entry.Finished = record.Finished.Round(0)
+
+	history.m.Lock()
+	defer history.m.Unlock()
+
+	history.insert(&entry)
+	if history.options.Store != nil {
+		_ = history.options.Store.Save(&entry) // the entry remains available until the server restarts
+	}
+	history.prune(time.Now())
+}
+
+// truncate returns a copy of lines holding at most the maximal number of lines.
+func (history *History) truncate(lines store.Lines) store.Lines {
+	keep := max(history.options.MaxLines, 0)
+	if len(lines.Lines) > keep {
+		lines.Lines = lines.Lines[len(lines.Lines)-keep:]
+	}
+	lines.Lines = slices.Clone(lines.Lines)
+	return lines
+}
+
+// insert inserts record into the history, replacing any record with the same id.
+// history.m must be held.
+func (history *History) insert(record *store.Record) {
+	history.records = slices.DeleteFunc(history.records, func(r *store.Record) bool { return r.ID == record.ID })
+
+	index, _ := slices.BinarySearchFunc(history.records, record, compare)
+	history.records = slices.Insert(history.records, index, record)
+}
+
+// prune removes records exceeding the limits of the history.
+// history.m must be held.
+func (history *History) prune(now time.Time) {
+	var removed []*store.Record
+	history.records = slices.DeleteFunc(history.records, func(record *store.Record) bool {
+		if history.options.MaxAge <= 0 {
+			return false
+		}
+
+		finished := record.Finished
+		if finished.IsZero() {
+			finished = record.Started
+		}
+		if now.Sub(finished) <= history.options.MaxAge {
+			return false
+		}
+		removed = append(removed, record)
+		return true
+	})
+	if limit := history.options.MaxEntries; limit > 0 && len(history.records) > limit {
+		removed = append(removed, history.records[limit:]...)
+		history.records = slices.Delete(history.records, limit, len(history.records))
+	}
+
+	if history.options.Store == nil {
+		return
+	}
+	for _, record := range removed {
+		_ = history.options.Store.Delete(record.ID)
+	}
+}
+
+// Get returns the record of the session with the given id.
+// The returned record must not be modified.
+func (history *History) Get(id string) (*store.Record, bool) {
+	if history == nil {
+		return nil, false
+	}
+
+	history.m.Lock()
+	defer history.m.Unlock()
+
+	history.prune(time.Now())
+	index := slices.IndexFunc(history.records, func(record *store.Record) bool { return record.ID == id })
+	if index < 0 {
+		return nil, false
+	}
+	return history.records[index], true
+}
+
+// Default and maximal number of records returned by a single call to [History.List].
+const (
+	DefaultLimit = 50
+	MaxLimit     = 1000
+)
+
+// Query selects records in the history.
+type Query struct {
+	// Status, if non-empty, only selects sessions with the given status, see [Status].
+	Status string
+
+	// Call, if non-empty, only selects sessions calling the process with the given name.
+	Call string
+
+	// After and Before, if non-zero, only select sessions started at or after, or before the respective time.
+	After, Before time.Time
+
+	// Visible, if non-nil, only selects sessions for which it returns true.
+	Visible func(record *store.Record) bool
+
+	// Cursor, if non-empty, continues a previous query, see [Page.Next].
+	Cursor string
+
+	// Limit is the maximal number of records returned.
+	// If it is not positive, [DefaultLimit] is used; it is at most [MaxLimit].
+	Limit int
+}
+
+// Page is a page of records returned by [History.List].
+type Page struct {
+	// Records holds the selected records, newest first.
+	// They must not be modified.
+	Records []*store.Record
+
+	// Next is the cursor to pass in a subsequent query to continue after these records.
+	// It is empty if there are no more records.
+	Next string
+}
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errInvalidStatus = errors.New("invalid status")
+)
+
+// List returns the records selected by query.
+// If the query is invalid, returns an error.
+func (history *History) List(query Query) (Page, error) {
+	if query.Status != "" && query.Status != StatusFulfilled && query.Status != StatusRejected {
+		return Page{}, fmt.Errorf("%w: %q", errInvalidStatus, query.Status)
+	}
+
+	var after *store.Record // records are returned after this position
+	if query.Cursor != "" {
+		var err error
+		after, err = parseCursor(query.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	if history == nil {
+		return Page{}, nil
+	}
+
+	history.m.Lock()
+	defer history.m.Unlock()
+
+	history.prune(time.Now())
+
+	start := 0
+	if after != nil {
+		start, _ = slices.BinarySearchFunc(history.records, after, compare)
+		if start < len(history.records) && history.records[start].ID == after.ID {
+			start++
+		}
+	}
+
+	var page Page
+	for _, record := range history.records[start:] {
+		if !query.matches(record) {
+			continue
+		}
+		if len(page.Records) == limit {
+			last := page.Records[len(page.Records)-1]
+			page.Next = formatCursor(last)
+			break
+		}
+		page.Records = append(page.Records, record)
+	}
+	return page, nil
+}
+
+// matches checks if query selects record.
+func (query Query) matches(record *store.Record) bool {
+	return (query.Status == "" || Status(record) == query.Status) &&
+		(query.Call == "" || record.Call.Call == query.Call) &&
+		(query.After.IsZero() || !record.Started.Before(query.After)) &&
+		(query.Before.IsZero() || record.Started.Before(query.Before)) &&
+		(query.Visible == nil || query.Visible(record))
+}
+
+// formatCursor formats a cursor pointing to the position of record.
+func formatCursor(record *store.Record) string {
+	return strconv.FormatInt(record.Started.UnixNano(), 10) + "." + record.ID
+}
+
+// parseCursor parses a cursor created by formatCursor.
+// It returns a record at the position of the cursor.
+func parseCursor(cursor string) (*store.Record, error) {
+	nanos, id, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	started, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidCursor, err)
+	}
+	return &store.Record{ID: id, Started: time.Unix(0, started)}, nil
+}
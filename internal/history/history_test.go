@@ -0,0 +1,192 @@
+//spellchecker:words history
+package history_test
+
+//spellchecker:words strconv testing time github process over websocket internal history proto store
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/history"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/store"
+)
+
+// epoch is the time the first test record started.
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// record returns a finished record with the given number, started i minutes after epoch.
+func record(i int, call string, reason error) *store.Record {
+	started := epoch.Add(time.Duration(i) * time.Minute)
+	return &store.Record{
+		ID:       "session-" + strconv.Itoa(i),
+		Call:     proto.CallMessage{Call: call},
+		Started:  started,
+		Finished: started.Add(time.Second),
+		Result:   &proto.Result{Reason: reason},
+	}
+}
+
+// ids returns the ids of the records in page.
+func ids(page history.Page) []string {
+	ids := make([]string, len(page.Records))
+	for i, record := range page.Records {
+		ids[i] = record.ID
+	}
+	return ids
+}
+
+// checkIDs checks that page holds the records with the given ids.
+func checkIDs(t *testing.T, page history.Page, want ...string) {
+	t.Helper()
+
+	got := ids(page)
+	if len(got) != len(want) {
+		t.Errorf("got ids %v, want %v", got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("got ids %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func TestHistory_List(t *testing.T) {
+	t.Parallel()
+
+	h := history.New(history.Options{MaxEntries: 10})
+	for i := range 5 {
+		var reason error
+		if i%2 == 1 {
+			reason = proto.ErrCancelClientRequest
+		}
+		call := "echo"
+		if i == 4 {
+			call = "status"
+		}
+		h.Add(record(i, call, reason))
+	}
+
+	for _, tt := range []struct {
+		name  string
+		query history.Query
+		want  []string
+	}{
+		{"all", history.Query{}, []string{"session-4", "session-3", "session-2", "session-1", "session-0"}},
+		{"status", history.Query{Status: history.StatusRejected}, []string{"session-3", "session-1"}},
+		{"call", history.Query{Call: "echo", Status: history.StatusFulfilled}, []string{"session-2", "session-0"}},
+		{"time", history.Query{After: epoch.Add(time.Minute), Before: epoch.Add(3 * time.Minute)}, []string{"session-2", "session-1"}},
+		{"visible", history.Query{Visible: func(r *store.Record) bool { return r.ID == "session-2" }}, []string{"session-2"}},
+	} {
+		page, err := h.List(tt.query)
+		if err != nil {
+			t.Errorf("%s: failed to list: %v", tt.name, err)
+			continue
+		}
+		checkIDs(t, page, tt.want...)
+		if page.Next != "" {
+			t.Errorf("%s: got next cursor %q on last page", tt.name, page.Next)
+		}
+	}
+
+	if _, err := h.List(history.Query{Status: "pending"}); err == nil {
+		t.Error("got no error for invalid status")
+	}
+	if _, err := h.List(history.Query{Cursor: "nonsense"}); err == nil {
+		t.Error("got no error for invalid cursor")
+	}
+}
+
+func TestHistory_List_pagination(t *testing.T) {
+	t.Parallel()
+
+	h := history.New(history.Options{MaxEntries: 10})
+	for i := range 5 {
+		h.Add(record(i, "echo", nil))
+	}
+
+	page, err := h.List(history.Query{Limit: 2})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	checkIDs(t, page, "session-4", "session-3")
+
+	// new sessions do not affect later pages
+	h.Add(record(5, "echo", nil))
+
+	page, err = h.List(history.Query{Limit: 2, Cursor: page.Next})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	checkIDs(t, page, "session-2", "session-1")
+
+	page, err = h.List(history.Query{Limit: 2, Cursor: page.Next})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	checkIDs(t, page, "session-0")
+	if page.Next != "" {
+		t.Errorf("got next cursor %q on last page", page.Next)
+	}
+}
+
+func TestHistory_retention(t *testing.T) {
+	t.Parallel()
+
+	dir, err := store.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	options := history.Options{MaxEntries: 2, MaxLines: 2, Store: dir}
+
+	h := history.New(options)
+	for i := range 3 {
+		entry := record(i, "echo", nil)
+		entry.Output = store.Lines{Lines: []string{"a", "b", "c"}, Total: 5}
+		h.Add(entry)
+	}
+
+	// only the newest sessions are kept
+	if _, ok := h.Get("session-0"); ok {
+		t.Error("oldest session was not removed")
+	}
+
+	// including their newest lines of output
+	entry, ok := h.Get("session-2")
+	if !ok {
+		t.Fatal("newest session was removed")
+	}
+	if len(entry.Output.Lines) != 2 || entry.Output.Lines[0] != "b" || entry.Output.Total != 5 {
+		t.Errorf("got output %v, want the newest two lines", entry.Output)
+	}
+
+	// the history is restored from the store
+	page, err := history.New(options).List(history.Query{})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	checkIDs(t, page, "session-2", "session-1")
+
+	// and old sessions expire
+	page, err = history.New(history.Options{MaxAge: time.Hour, Store: dir}).List(history.Query{})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	checkIDs(t, page)
+}
+
+func TestNew_disabled(t *testing.T) {
+	t.Parallel()
+
+	h := history.New(history.Options{MaxLines: 10})
+	if h != nil {
+		t.Fatal("got history for options without retention")
+	}
+
+	h.Add(record(0, "echo", nil))
+	if page, err := h.List(history.Query{}); err != nil || len(page.Records) != 0 {
+		t.Errorf("got page %v and error %v, want empty", ids(page), err)
+	}
+}
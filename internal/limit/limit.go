@@ -0,0 +1,305 @@
+// Package limit limits the number of processes running at once.
+//
+//spellchecker:words limit
+package limit
+
+//spellchecker:words cmp context http slices sync time github process over websocket proto
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Options configure the limits of a [Limiter].
+type Options struct {
+	// Max is the maximal number of processes running at once.
+	// If it is not positive, there is no global limit.
+	Max int
+
+	// PerProcess maps names of processes to the maximal number of processes with that name running at once.
+	// Processes without a positive entry are only limited by Max.
+	PerProcess map[string]int
+
+	// QueueSize is the maximal number of processes waiting for other processes to finish.
+	// Processes exceeding it are rejected with an error wrapping [proto.ErrQueueFull].
+	// If it is not positive, processes are rejected as soon as a limit is reached.
+	QueueSize int
+
+	// MaxPriority returns the highest priority the client making r may request for the process with the given name.
+	// Higher priorities are lowered to it, see [proto.CallMessage].
+	// If nil, clients may not request priorities above 0.
+	MaxPriority func(r *http.Request, name string) int
+
+	// Aging is the time after which the priority of a waiting process is raised by one.
+	// This ensures that processes with a low priority eventually run.
+	// If it is zero, [DefaultAging] is used; if it is negative, priorities are not raised.
+	Aging time.Duration
+}
+
+// DefaultAging is the default for [Options.Aging].
+const DefaultAging = 30 * time.Second
+
+// Enabled reports if these options impose any limits.
+func (opts Options) Enabled() bool {
+	if opts.Max > 0 {
+		return true
+	}
+	for _, limit := range opts.PerProcess {
+		if limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Limiter hands out slots for running processes.
+// Processes exceeding the limits wait in a queue.
+// Once a slot is available, it is given to the waiting process with the highest priority, see [Options.Aging].
+// Processes with the same priority are dispatched in the order they arrived.
+//
+// A nil Limiter imposes no limits.
+// Limiter is safe for concurrent use.
+type Limiter struct {
+	options Options
+
+	m       sync.Mutex
+	running int            // total number of slots held
+	byName  map[string]int // number of slots held by name
+	queue   []*Ticket      // tickets waiting for a slot, in the order they are dispatched as of the last change
+}
+
+// New creates a new limiter with the given options.
+// If the options do not impose any limits, returns nil.
+func New(options Options) *Limiter {
+	if !options.Enabled() {
+		return nil
+	}
+	if options.Aging == 0 {
+		options.Aging = DefaultAging
+	}
+	return &Limiter{
+		options: options,
+		byName:  make(map[string]int),
+	}
+}
+
+// Ticket is a slot, or a place in the queue for one, handed out by a [Limiter].
+type Ticket struct {
+	limiter  *Limiter
+	name     string
+	priority int
+	queued   time.Time // time the ticket was placed in the queue
+
+	// protected by limiter.m
+	stage    stage
+	position int           // position in the queue starting at 1, or 0 if not queued
+	changed  chan struct{} // closed and replaced whenever the position changes
+}
+
+type stage uint8
+
+const (
+	stageQueued stage = iota
+	stageHolding
+	stageReleased
+)
+
+// Reserve reserves a slot for the process requested by call, made by the client making r.
+// If no slot is available, the ticket is placed in the queue with the priority of the call, see [Options.MaxPriority].
+// If the queue is full, returns an error wrapping [proto.ErrQueueFull].
+//
+// The returned ticket must be released using [Ticket.Release].
+func (limiter *Limiter) Reserve(r *http.Request, call proto.CallMessage) (*Ticket, error) {
+	ticket := &Ticket{limiter: limiter, name: call.Call, changed: make(chan struct{})}
+	if limiter == nil {
+		ticket.stage = stageHolding
+		return ticket, nil
+	}
+
+	ticket.priority = min(call.Priority, limiter.maxPriority(r, call.Call))
+
+	limiter.m.Lock()
+	defer limiter.m.Unlock()
+
+	// queued tickets are only waiting if they can not take a slot, so it is fair to take one right away.
+	if limiter.available(ticket.name) {
+		limiter.take(ticket)
+		return ticket, nil
+	}
+
+	if len(limiter.queue) >= limiter.options.QueueSize {
+		return nil, fmt.Errorf("%w: %d process(es) waiting", proto.ErrQueueFull, len(limiter.queue))
+	}
+	ticket.stage = stageQueued
+	ticket.queued = time.Now()
+	limiter.queue = append(limiter.queue, ticket)
+	limiter.sort()
+	limiter.renumber()
+	return ticket, nil
+}
+
+// maxPriority returns the highest priority the client making r may request for the process with the given name.
+func (limiter *Limiter) maxPriority(r *http.Request, name string) int {
+	if limiter.options.MaxPriority == nil {
+		return 0
+	}
+	return limiter.options.MaxPriority(r, name)
+}
+
+// compare orders tickets in the order they should be dispatched at the given time.
+func (limiter *Limiter) compare(now time.Time) func(a, b *Ticket) int {
+	return func(a, b *Ticket) int {
+		return cmp.Or(
+			cmp.Compare(limiter.effective(b, now), limiter.effective(a, now)),
+			a.queued.Compare(b.queued),
+		)
+	}
+}
+
+// effective returns the priority of ticket at the given time, including the effects of aging.
+func (limiter *Limiter) effective(ticket *Ticket, now time.Time) float64 {
+	priority := float64(ticket.priority)
+	if limiter.options.Aging > 0 {
+		priority += float64(now.Sub(ticket.queued)) / float64(limiter.options.Aging)
+	}
+	return priority
+}
+
+// available checks if a slot for the given name is available.
+// limiter.m must be held.
+func (limiter *Limiter) available(name string) bool {
+	if limiter.options.Max > 0 && limiter.running >= limiter.options.Max {
+		return false
+	}
+	if limit := limiter.options.PerProcess[name]; limit > 0 && limiter.byName[name] >= limit {
+		return false
+	}
+	return true
+}
+
+// take gives a slot to ticket.
+// limiter.m must be held.
+func (limiter *Limiter) take(ticket *Ticket) {
+	limiter.running++
+	limiter.byName[ticket.name]++
+	ticket.stage = stageHolding
+}
+
+// dispatch gives available slots to queued tickets in order of their priority, and notifies queued tickets of their new positions.
+// limiter.m must be held.
+func (limiter *Limiter) dispatch() {
+	limiter.sort()
+	limiter.queue = slices.DeleteFunc(limiter.queue, func(ticket *Ticket) bool {
+		if !limiter.available(ticket.name) {
+			return false
+		}
+		limiter.take(ticket)
+		ticket.position = 0
+		ticket.notify()
+		return true
+	})
+	limiter.renumber()
+}
+
+// sort sorts the queue in the order tickets should be dispatched now.
+// limiter.m must be held.
+func (limiter *Limiter) sort() {
+	slices.SortStableFunc(limiter.queue, limiter.compare(time.Now()))
+}
+
+// renumber updates the positions of all queued tickets, notifying those whose position changed.
+// limiter.m must be held.
+func (limiter *Limiter) renumber() {
+	for i, ticket := range limiter.queue {
+		if ticket.position != i+1 {
+			ticket.position = i + 1
+			ticket.notify()
+		}
+	}
+}
+
+// notify notifies anyone waiting for the position of ticket to change.
+// limiter.m must be held.
+func (ticket *Ticket) notify() {
+	close(ticket.changed)
+	ticket.changed = make(chan struct{})
+}
+
+// State returns the position of this ticket in the queue, starting at 1.
+// If the ticket holds a slot or has been released, the position is 0.
+// It also returns a channel that is closed once the position changes.
+//
+// Positions are only updated when the queue changes.
+// As waiting tickets age, their order may change in between without being notified.
+func (ticket *Ticket) State() (position int, changed <-chan struct{}) {
+	if ticket.limiter == nil {
+		return 0, nil
+	}
+
+	ticket.limiter.m.Lock()
+	defer ticket.limiter.m.Unlock()
+
+	return ticket.position, ticket.changed
+}
+
+// Wait waits until this ticket holds a slot.
+//
+// If report is not nil, it is called with the position of the ticket whenever it changes while waiting.
+// If the ticket had to wait, report is finally called with position 0.
+//
+// If ctx is cancelled before a slot is available, the ticket is released and an error wrapping the cause of ctx is returned.
+func (ticket *Ticket) Wait(ctx context.Context, report func(position int)) error {
+	var reported int
+	for {
+		position, changed := ticket.State()
+		if report != nil && position != reported {
+			report(position)
+			reported = position
+		}
+		if position == 0 {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			ticket.Release()
+			return fmt.Errorf("cancelled while queued: %w", context.Cause(ctx))
+		}
+	}
+}
+
+// Release releases the slot held by this ticket, or removes it from the queue.
+// Releasing a ticket more than once has no effect.
+func (ticket *Ticket) Release() {
+	limiter := ticket.limiter
+	if limiter == nil {
+		return
+	}
+
+	limiter.m.Lock()
+	defer limiter.m.Unlock()
+
+	switch ticket.stage {
+	case stageHolding:
+		limiter.running--
+		if limiter.byName[ticket.name]--; limiter.byName[ticket.name] == 0 {
+			delete(limiter.byName, ticket.name)
+		}
+	case stageQueued:
+		limiter.queue = slices.DeleteFunc(limiter.queue, func(t *Ticket) bool { return t == ticket })
+	case stageReleased:
+		return
+	}
+
+	ticket.stage = stageReleased
+	ticket.position = 0
+	ticket.notify()
+	limiter.dispatch()
+}
@@ -0,0 +1,190 @@
+//spellchecker:words limit
+package limit_test
+
+//spellchecker:words context errors http httptest testing time github process over websocket internal limit proto
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// reservePriority reserves a ticket with the given name and priority and fails the test if that is not possible.
+func reservePriority(t *testing.T, limiter *limit.Limiter, name string, priority int) *limit.Ticket {
+	t.Helper()
+
+	ticket, err := limiter.Reserve(httptest.NewRequest(http.MethodGet, "/", nil), proto.CallMessage{Call: name, Priority: priority})
+	if err != nil {
+		t.Fatalf("failed to reserve %q: %v", name, err)
+	}
+	return ticket
+}
+
+// reserve is like reservePriority, using the default priority.
+func reserve(t *testing.T, limiter *limit.Limiter, name string) *limit.Ticket {
+	t.Helper()
+
+	return reservePriority(t, limiter, name, 0)
+}
+
+// checkPosition checks that ticket is at the given position.
+func checkPosition(t *testing.T, ticket *limit.Ticket, want int) {
+	t.Helper()
+
+	if got, _ := ticket.State(); got != want {
+		t.Errorf("got position %d, want %d", got, want)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.New(limit.Options{Max: 2, PerProcess: map[string]int{"backup": 1}, QueueSize: 2})
+
+	backup := reserve(t, limiter, "backup")
+	checkPosition(t, backup, 0)
+
+	// the second backup has to wait for the first one
+	backup2 := reserve(t, limiter, "backup")
+	checkPosition(t, backup2, 1)
+
+	// but other processes may overtake it
+	status := reserve(t, limiter, "status")
+	checkPosition(t, status, 0)
+
+	// the global limit is reached now
+	status2 := reserve(t, limiter, "status")
+	checkPosition(t, status2, 2)
+
+	// and the queue is full
+	if _, err := limiter.Reserve(httptest.NewRequest(http.MethodGet, "/", nil), proto.CallMessage{Call: "status"}); !errors.Is(err, proto.ErrQueueFull) {
+		t.Errorf("got error %v, want %v", err, proto.ErrQueueFull)
+	}
+
+	// releasing a status only lets the second status run, as the backup is still limited
+	status.Release()
+	checkPosition(t, backup2, 1)
+	checkPosition(t, status2, 0)
+
+	// releasing the backup lets the second one run
+	_, changed := backup2.State()
+	backup.Release()
+	select {
+	case <-changed:
+	default:
+		t.Error("position change was not notified")
+	}
+	if err := backup2.Wait(t.Context(), nil); err != nil {
+		t.Errorf("failed to wait: %v", err)
+	}
+
+	// releasing twice has no effect
+	backup.Release()
+	backup2.Release()
+	status2.Release()
+	for range 2 {
+		checkPosition(t, reserve(t, limiter, "status"), 0)
+	}
+}
+
+func TestTicket_Wait(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.New(limit.Options{Max: 1, QueueSize: 2})
+
+	first := reserve(t, limiter, "a")
+	second := reserve(t, limiter, "b")
+	third := reserve(t, limiter, "c")
+
+	// cancelling a waiting ticket removes it from the queue
+	ctx, cancel := context.WithCancelCause(t.Context())
+	cancel(proto.ErrCancelClientRequest)
+	if err := second.Wait(ctx, nil); !errors.Is(err, proto.ErrCancelClientRequest) {
+		t.Errorf("got error %v, want %v", err, proto.ErrCancelClientRequest)
+	}
+	checkPosition(t, third, 1)
+
+	// positions are reported until the ticket holds a slot
+	var positions []int
+	go first.Release()
+	if err := third.Wait(t.Context(), func(position int) { positions = append(positions, position) }); err != nil {
+		t.Errorf("failed to wait: %v", err)
+	}
+	if len(positions) != 2 || positions[0] != 1 || positions[1] != 0 {
+		t.Errorf("got positions %v, want [1 0]", positions)
+	}
+}
+
+func TestLimiter_priority(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.New(limit.Options{
+		Max:       1,
+		QueueSize: 10,
+		Aging:     -1,
+		MaxPriority: func(r *http.Request, name string) int {
+			if name == "interactive" {
+				return 5
+			}
+			return 0
+		},
+	})
+
+	running := reserve(t, limiter, "running")
+
+	// priorities above the permitted ones are lowered
+	nightly := reservePriority(t, limiter, "nightly", -5)
+	normal := reservePriority(t, limiter, "normal", 10)
+	interactive := reservePriority(t, limiter, "interactive", 10)
+	later := reservePriority(t, limiter, "interactive", 5)
+
+	checkPosition(t, interactive, 1)
+	checkPosition(t, later, 2)
+	checkPosition(t, normal, 3)
+	checkPosition(t, nightly, 4)
+
+	for _, next := range []*limit.Ticket{interactive, later, normal, nightly} {
+		running.Release()
+		checkPosition(t, next, 0)
+		running = next
+	}
+}
+
+func TestLimiter_aging(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.New(limit.Options{Max: 1, QueueSize: 10, Aging: 10 * time.Millisecond})
+
+	running := reserve(t, limiter, "running")
+	nightly := reservePriority(t, limiter, "nightly", -1)
+
+	// after waiting long enough, the nightly process overtakes newer ones with a higher priority
+	time.Sleep(100 * time.Millisecond)
+	normal := reserve(t, limiter, "normal")
+	checkPosition(t, nightly, 1)
+	checkPosition(t, normal, 2)
+
+	running.Release()
+	checkPosition(t, nightly, 0)
+	checkPosition(t, normal, 1)
+}
+
+func TestNew_unlimited(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.New(limit.Options{QueueSize: 10})
+	if limiter != nil {
+		t.Fatal("got limiter for options without limits")
+	}
+
+	for range 100 {
+		ticket := reserve(t, limiter, "a")
+		checkPosition(t, ticket, 0)
+		ticket.Release()
+	}
+}
@@ -1,9 +1,11 @@
 //spellchecker:words omap
 package omap
 
-//spellchecker:words encoding json
+//spellchecker:words encoding json errors
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 )
 
 // OrderedMap is like map[string]json.RawMessage, but maintains order.
@@ -34,3 +36,43 @@ func (om *OrderedMap) Get(key string) (value json.RawMessage, ok bool) {
 	}
 	return nil, false
 }
+
+var (
+	errEmptyPath   = errors.New("empty path")
+	errKeyNotFound = errors.New("key not found")
+)
+
+// SetPath sets the entry at the given path of nested objects to the given value.
+// All but the last element of the path must already exist and be objects.
+func (om *OrderedMap) SetPath(value json.RawMessage, path ...string) error {
+	if len(path) == 0 {
+		return errEmptyPath
+	}
+	if len(path) == 1 {
+		om.Set(path[0], value)
+		return nil
+	}
+
+	// decode the child object
+	raw, ok := om.Get(path[0])
+	if !ok {
+		return fmt.Errorf("%w: %q", errKeyNotFound, path[0])
+	}
+	var child OrderedMap
+	if err := json.Unmarshal(raw, &child); err != nil {
+		return fmt.Errorf("failed to decode %q: %w", path[0], err)
+	}
+
+	// update it
+	if err := child.SetPath(value, path[1:]...); err != nil {
+		return fmt.Errorf("%q: %w", path[0], err)
+	}
+
+	// and store it again
+	raw, err := json.Marshal(child)
+	if err != nil {
+		return fmt.Errorf("failed to encode %q: %w", path[0], err)
+	}
+	om.Set(path[0], raw)
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package origin checks the origin of requests and implements cross-origin resource sharing.
+//
+//spellchecker:words origin
+package origin
+
+//spellchecker:words http strconv strings time github process over websocket proto
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Any can be used in [Options.AllowedOrigins] to allow requests from any origin.
+const Any = "*"
+
+// Options configure which origins may access a server.
+//
+// Requests without an Origin header, such as those of non-browser clients, and requests from the origin of the server itself are always allowed.
+// The origin of the server uses the scheme of the request as received by the server.
+// Servers behind a proxy terminating TLS should thus list their public origin in AllowedOrigins.
+type Options struct {
+	// AllowedOrigins holds additional origins, such as "https://example.com", that may access the server.
+	// The special value [Any] allows all origins.
+	AllowedOrigins []string
+
+	// AllowCredentials allows browsers to send credentials, such as cookies, along with cross-origin REST requests.
+	// Credentials are only allowed for origins listed explicitly, never for those only allowed by [Any].
+	AllowCredentials bool
+
+	// MaxAge is the time browsers may cache the result of a preflight request.
+	// If it is not positive, browsers use their default.
+	MaxAge time.Duration
+}
+
+// Allowed reports if r may access the server.
+func (opts Options) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(origin, r) {
+		return true
+	}
+	allowed, _ := opts.allows(origin)
+	return allowed
+}
+
+// sameOrigin checks if origin is the origin of the server r was sent to.
+func sameOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return strings.EqualFold(u.Scheme, scheme) && strings.EqualFold(u.Host, r.Host)
+}
+
+// allows checks if origin is allowed to access the server.
+// explicit reports if it is listed explicitly, rather than only allowed by [Any].
+func (opts Options) allows(origin string) (allowed, explicit bool) {
+	for _, o := range opts.AllowedOrigins {
+		if o == Any {
+			allowed = true
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true, true
+		}
+	}
+	return allowed, false
+}
+
+// Forbid writes a response rejecting a request that is not allowed.
+func Forbid(w http.ResponseWriter) {
+	http.Error(w, "origin not allowed", http.StatusForbidden)
+}
+
+// CORS wraps handler to only serve requests from allowed origins.
+//
+// It answers preflight requests from allowed origins, and adds the headers required by browsers to other cross-origin responses.
+// Requests from origins that are not allowed are rejected.
+func (opts Options) CORS(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || sameOrigin(origin, r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		allowed, explicit := opts.allows(origin)
+		if !allowed {
+			Forbid(w)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		if opts.AllowCredentials && explicit {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// answer preflight requests directly
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+
+			header.Set("Access-Control-Allow-Methods", "GET, POST")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				header.Set("Access-Control-Allow-Headers", headers)
+			}
+			if opts.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		header.Set("Access-Control-Expose-Headers", proto.SessionSecretHeader+", Retry-After")
+		handler.ServeHTTP(w, r)
+	})
+}
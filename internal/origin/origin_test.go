@@ -0,0 +1,75 @@
+//spellchecker:words origin
+package origin_test
+
+//spellchecker:words crypto http httptest testing github process over websocket internal origin
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/origin"
+)
+
+func TestOptions_Allowed(t *testing.T) {
+	t.Parallel()
+
+	opts := origin.Options{AllowedOrigins: []string{"https://frontend.example/"}}
+
+	for _, tt := range []struct {
+		name   string
+		origin string
+		tls    bool
+		want   bool
+	}{
+		{"no origin", "", false, true},
+		{"same origin", "http://server.example", false, true},
+		{"same origin with tls", "https://server.example", true, true},
+		{"other scheme", "http://server.example", true, false},
+		{"other scheme without tls", "https://server.example", false, false},
+		{"allowed origin", "https://frontend.example", false, true},
+		{"other origin", "https://other.example", false, false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://server.example/", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+
+		if got := opts.Allowed(r); got != tt.want {
+			t.Errorf("%s: got allowed %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOptions_CORS_credentials(t *testing.T) {
+	t.Parallel()
+
+	const listed = "https://frontend.example"
+	handler := origin.Options{
+		AllowedOrigins:   []string{origin.Any, listed},
+		AllowCredentials: true,
+	}.CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tt := range []struct {
+		origin      string
+		credentials string
+	}{
+		{listed, "true"},
+		{"https://other.example", ""}, // only allowed by Any
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://server.example/", nil)
+		r.Header.Set("Origin", tt.origin)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.origin {
+			t.Errorf("%s: got allowed origin %q, want %q", tt.origin, got, tt.origin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+			t.Errorf("%s: got allow credentials %q, want %q", tt.origin, got, tt.credentials)
+		}
+	}
+}
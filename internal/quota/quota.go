@@ -0,0 +1,230 @@
+// Package quota limits how many processes a single client may start.
+//
+//spellchecker:words quota
+package quota
+
+//spellchecker:words math http sync time github process over websocket proto
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Options configure the quota of each client.
+type Options struct {
+	// StartsPerMinute is the number of processes a single client may start per minute on average.
+	// If it is not positive, the rate of starts is not limited.
+	StartsPerMinute float64
+
+	// Burst is the number of processes a single client may start at once before being limited by StartsPerMinute.
+	// Defaults to StartsPerMinute, but at least 1.
+	Burst int
+
+	// MaxSessions is the maximal number of processes of a single client running or waiting to start at once.
+	// If it is not positive, the number of processes is not limited.
+	MaxSessions int
+
+	// Key returns the key identifying the client making a request.
+	// Clients with the same key share the same quota.
+	//
+	// Defaults to the name of the authenticated principal, see [proto.PrincipalFrom], or the ip address of the client.
+	// Servers behind a proxy should use a function that considers the headers set by the proxy.
+	Key func(r *http.Request) string
+}
+
+// Enabled reports if these options impose any limits.
+func (opts Options) Enabled() bool {
+	return opts.StartsPerMinute > 0 || opts.MaxSessions > 0
+}
+
+// DefaultKey is the default for [Options.Key].
+func DefaultKey(r *http.Request) string {
+	if principal := proto.PrincipalFrom(r.Context()); principal != nil {
+		return "principal:" + principal.Name
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// sweepInterval is the interval in which clients without any state are forgotten.
+const sweepInterval = time.Minute
+
+// Quota keeps track of the processes started by each client.
+//
+// A nil Quota imposes no limits.
+// Quota is safe for concurrent use.
+type Quota struct {
+	options Options
+	rate    float64 // tokens per second
+	burst   float64 // capacity of each bucket
+
+	m         sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// client holds the state of a single client.
+type client struct {
+	tokens   float64   // tokens in the bucket at last
+	last     time.Time // time tokens was last updated
+	sessions int       // number of processes running or waiting
+}
+
+// New creates a new quota with the given options.
+// If the options do not impose any limits, returns nil.
+func New(options Options) *Quota {
+	if !options.Enabled() {
+		return nil
+	}
+	if options.Key == nil {
+		options.Key = DefaultKey
+	}
+
+	burst := options.Burst
+	if burst <= 0 {
+		burst = max(1, int(options.StartsPerMinute))
+	}
+
+	return &Quota{
+		options: options,
+		rate:    options.StartsPerMinute / 60,
+		burst:   float64(burst),
+		clients: make(map[string]*client),
+	}
+}
+
+// sessionsBackoff is the time after which a client that has too many processes running may try again.
+// The time when one of its processes finishes is not known, so clients are asked to poll with a small fixed back-off.
+const sessionsBackoff = 5 * time.Second
+
+// Error is returned when a client has exceeded its quota.
+// It wraps [proto.ErrRateLimited].
+type Error struct {
+	// RetryAfter is the time after which the client may try again.
+	// If the client has too many processes running, it is a small fixed back-off.
+	RetryAfter time.Duration
+
+	sessions bool // the limit on the number of processes was exceeded
+}
+
+func (err *Error) Error() string {
+	if err.sessions {
+		return proto.ErrRateLimited.Error() + ": too many processes running"
+	}
+	return fmt.Sprintf("%s: too many processes started, retry after %s", proto.ErrRateLimited, err.RetryAfter.Round(time.Second))
+}
+
+func (err *Error) Unwrap() error {
+	return proto.ErrRateLimited
+}
+
+// ErrorCode implements [proto.CodedError].
+func (err *Error) ErrorCode() proto.ErrorCode {
+	return proto.CodeRateLimited
+}
+
+// ErrorDetails implements [proto.CodedError].
+func (err *Error) ErrorDetails() any {
+	if err.RetryAfter <= 0 {
+		return nil
+	}
+	return struct {
+		RetryAfter int `json:"retryAfter"` // in seconds
+	}{RetryAfter: seconds(err.RetryAfter)}
+}
+
+// RetryAfterSeconds returns RetryAfter rounded up to full seconds.
+func (err *Error) RetryAfterSeconds() int {
+	return seconds(err.RetryAfter)
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Acquire records that the client making r starts a new process.
+// If the client has exceeded its quota, returns an [*Error].
+//
+// Otherwise, the returned function must be called once the process has finished.
+func (quota *Quota) Acquire(r *http.Request) (release func(), err error) {
+	if quota == nil {
+		return func() {}, nil
+	}
+
+	key := quota.options.Key(r)
+	now := time.Now()
+
+	quota.m.Lock()
+	defer quota.m.Unlock()
+
+	quota.sweep(now)
+
+	c, ok := quota.clients[key]
+	if !ok {
+		c = &client{tokens: quota.burst, last: now}
+		quota.clients[key] = c
+	}
+
+	if quota.options.MaxSessions > 0 && c.sessions >= quota.options.MaxSessions {
+		return nil, &Error{RetryAfter: sessionsBackoff, sessions: true}
+	}
+
+	if quota.rate > 0 {
+		quota.refill(c, now)
+		if c.tokens < 1 {
+			wait := time.Duration((1 - c.tokens) / quota.rate * float64(time.Second))
+			return nil, &Error{RetryAfter: wait}
+		}
+		c.tokens--
+	}
+
+	c.sessions++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			quota.m.Lock()
+			defer quota.m.Unlock()
+
+			c.sessions--
+		})
+	}, nil
+}
+
+// refill adds the tokens accumulated since the last update to the bucket of c.
+// quota.m must be held.
+func (quota *Quota) refill(c *client, now time.Time) {
+	c.tokens = min(quota.burst, c.tokens+now.Sub(c.last).Seconds()*quota.rate)
+	c.last = now
+}
+
+// sweep forgets clients without running processes and with a full bucket, at most once per [sweepInterval].
+// quota.m must be held.
+func (quota *Quota) sweep(now time.Time) {
+	if now.Sub(quota.lastSweep) < sweepInterval {
+		return
+	}
+	quota.lastSweep = now
+
+	for key, c := range quota.clients {
+		if c.sessions > 0 {
+			continue
+		}
+		if quota.rate > 0 {
+			quota.refill(c, now)
+			if c.tokens < quota.burst {
+				continue
+			}
+		}
+		delete(quota.clients, key)
+	}
+}
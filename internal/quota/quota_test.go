@@ -0,0 +1,107 @@
+//spellchecker:words quota
+package quota_test
+
+//spellchecker:words errors http httptest testing time github process over websocket internal quota proto
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/quota"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// request returns a request from the given remote address and principal.
+func request(addr string, principal *proto.Principal) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = addr
+	if principal != nil {
+		r = r.WithContext(proto.WithPrincipal(r.Context(), principal))
+	}
+	return r
+}
+
+func TestQuota_rate(t *testing.T) {
+	t.Parallel()
+
+	q := quota.New(quota.Options{StartsPerMinute: 1, Burst: 2})
+	alice := request("192.0.2.1:1234", nil)
+
+	for range 2 {
+		release, err := q.Acquire(alice)
+		if err != nil {
+			t.Fatalf("failed to acquire: %v", err)
+		}
+		release()
+	}
+
+	// the bucket is empty now
+	_, err := q.Acquire(request("192.0.2.1:5678", nil))
+	var qerr *quota.Error
+	if !errors.As(err, &qerr) || !errors.Is(err, proto.ErrRateLimited) {
+		t.Fatalf("got error %v, want %v", err, proto.ErrRateLimited)
+	}
+	if qerr.RetryAfter <= 0 || qerr.RetryAfter > time.Minute {
+		t.Errorf("got retry after %s, want at most a minute", qerr.RetryAfter)
+	}
+	if code, details := proto.CodeOf(err); code != proto.CodeRateLimited || details == nil {
+		t.Errorf("got code %q and details %v, want %q with details", code, details, proto.CodeRateLimited)
+	}
+
+	// but other clients are not affected
+	if _, err := q.Acquire(request("192.0.2.2:1234", nil)); err != nil {
+		t.Errorf("failed to acquire for other client: %v", err)
+	}
+}
+
+func TestQuota_sessions(t *testing.T) {
+	t.Parallel()
+
+	q := quota.New(quota.Options{MaxSessions: 1})
+	bob := &proto.Principal{Name: "bob"}
+
+	release, err := q.Acquire(request("192.0.2.1:1234", bob))
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+
+	// the same principal is limited regardless of address
+	_, err = q.Acquire(request("192.0.2.2:1234", bob))
+	var qerr *quota.Error
+	if !errors.As(err, &qerr) || !errors.Is(err, proto.ErrRateLimited) {
+		t.Fatalf("got error %v, want %v", err, proto.ErrRateLimited)
+	}
+
+	// and asked to retry later, even though the time a process finishes is not known
+	if qerr.RetryAfterSeconds() <= 0 {
+		t.Errorf("got retry after %s, want positive", qerr.RetryAfter)
+	}
+
+	// releasing twice only counts once
+	release()
+	release()
+
+	for range 2 {
+		release, err := q.Acquire(request("192.0.2.2:1234", bob))
+		if err != nil {
+			t.Fatalf("failed to acquire after release: %v", err)
+		}
+		release()
+	}
+}
+
+func TestNew_unlimited(t *testing.T) {
+	t.Parallel()
+
+	q := quota.New(quota.Options{Burst: 10})
+	if q != nil {
+		t.Fatal("got quota for options without limits")
+	}
+	for range 100 {
+		if _, err := q.Acquire(request("192.0.2.1:1234", nil)); err != nil {
+			t.Fatalf("failed to acquire: %v", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Package reject writes rejected results as http responses.
+//
+//spellchecker:words reject
+package reject
+
+//spellchecker:words errors http strconv github process over websocket proto
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Status returns the http status code corresponding to the given error code.
+func Status(code proto.ErrorCode) int {
+	switch code {
+	case proto.CodeUnknownProcess:
+		return http.StatusNotFound
+	case proto.CodeInvalidArgs:
+		return http.StatusBadRequest
+	case proto.CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case proto.CodeAuthorizationDenied:
+		return http.StatusForbidden
+	case proto.CodeQueueFull:
+		return http.StatusServiceUnavailable
+	case proto.CodeRateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// RetryAfter may be implemented by errors to tell clients when to try again.
+type RetryAfter interface {
+	error
+
+	// RetryAfterSeconds returns the number of seconds after which the client may try again, or 0 if unknown.
+	RetryAfterSeconds() int
+}
+
+// Write writes err to w as a rejected [proto.Result].
+// The status code is determined from the code of the error, see [proto.CodeOf] and [Status].
+// If err wraps a [RetryAfter], the Retry-After header is set accordingly.
+func Write(w http.ResponseWriter, err error) {
+	code, _ := proto.CodeOf(err)
+
+	result := proto.Result{Reason: err}
+	data, _ := result.MarshalJSON()
+
+	var retry RetryAfter
+	if errors.As(err, &retry) && retry.RetryAfterSeconds() > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retry.RetryAfterSeconds()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(Status(code))
+	_, _ = w.Write(data)
+}
+
+// Authenticate authenticates r using the given authenticator.
+// If authenticator is nil, returns r unchanged.
+//
+// Upon success, returns a request whose context holds the principal, see [proto.PrincipalFrom].
+// Otherwise, writes an appropriate response to w and returns nil.
+func Authenticate(w http.ResponseWriter, r *http.Request, authenticator proto.Authenticator) *http.Request {
+	if authenticator == nil {
+		return r
+	}
+
+	principal, err := authenticator.Authenticate(r)
+	if err != nil {
+		if challenger, ok := authenticator.(proto.Challenger); ok {
+			w.Header().Set("WWW-Authenticate", challenger.Challenge())
+		}
+		Write(w, err)
+		return nil
+	}
+
+	return r.WithContext(proto.WithPrincipal(r.Context(), principal))
+}
@@ -0,0 +1,188 @@
+//spellchecker:words rest impl
+package rest_impl
+
+//spellchecker:words errors http strconv strings time
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEventsKeepAlive is the maximal interval between keepalive comments sent on an event stream.
+const maxEventsKeepAlive = 15 * time.Second
+
+// serveEvents streams the output and result of a session as server-sent events.
+//
+// Each line of output is sent as an "output" event, each line of error output as a "stderr" event.
+// When lines were dropped before they could be sent, a "dropped" or "stderrDropped" event holding the number of lines is sent.
+// While the process waits to start, a "queued" event holding its position in the queue is sent whenever it changes, followed by one holding 0 once it starts.
+// Once the process finishes, a "result" event holding the json-encoded result is sent and the stream ends.
+//
+// Each event has an id of the form "cursor-stderrCursor", see [Status].
+// Clients may pass it via the Last-Event-ID header to resume the stream after the respective event.
+func (server *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	// extract the id from the path
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "did not provide id", http.StatusBadRequest)
+		return
+	}
+
+	// get the session
+	session, err := server.vapor.Get(id)
+	if err != nil {
+		http.Error(w, "process not found", http.StatusNotFound)
+		return
+	}
+
+	// check that the client may access it
+	if !server.authorize(w, r, session) {
+		return
+	}
+
+	// parse the cursors to resume from
+	since, stderrSince, err := parseEventID(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// periodically send a keepalive, and keep the session from expiring
+	keepalive := time.NewTicker(min(maxEventsKeepAlive, server.options.Timeout/2))
+	defer keepalive.Stop()
+
+	var queued int // position in the queue last sent
+	for {
+		// get the channels before the status, so that we don't miss any changes.
+		outChanged, errChanged := session.Changed()
+		position, queueChanged := session.Queued()
+
+		if position != queued {
+			if err := writeEvent(w, formatEventID(since, stderrSince), "queued", strconv.Itoa(position)); err != nil {
+				return
+			}
+			queued = position
+		}
+
+		status := session.Status(since, stderrSince)
+
+		if err := writeEventLines(w, "output", "dropped", status.Buffer, status.Dropped, status.Cursor, func(seq uint64) string {
+			return formatEventID(seq, stderrSince)
+		}); err != nil {
+			return
+		}
+		since = status.Cursor
+
+		if err := writeEventLines(w, "stderr", "stderrDropped", status.Stderr, status.StderrDropped, status.StderrCursor, func(seq uint64) string {
+			return formatEventID(since, seq)
+		}); err != nil {
+			return
+		}
+		stderrSince = status.StderrCursor
+
+		// the process has finished
+		if status.Result != nil {
+			data, err := status.Result.MarshalJSON()
+			if err != nil {
+				return
+			}
+			_ = writeEvent(w, formatEventID(since, stderrSince), "result", string(data))
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-outChanged:
+		case <-errChanged:
+		case <-queueChanged:
+		case <-session.Done():
+		case <-keepalive.C:
+			if _, err := server.vapor.Get(id); err != nil {
+				return
+			}
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEventLines writes an event for each line in buffer, the last of which has sequence number cursor.
+// If dropped is non-zero, first writes a dropped event.
+// The id of each event is determined by calling id with the sequence number of the respective line.
+func writeEventLines(w io.Writer, event, droppedEvent, buffer string, dropped uint64, cursor uint64, id func(seq uint64) string) error {
+	var lines []string
+	if buffer != "" {
+		lines = strings.Split(buffer, "\n")
+	}
+
+	// sequence number of the line before the first line
+	seq := cursor - uint64(len(lines))
+
+	if dropped != 0 {
+		if err := writeEvent(w, id(seq), droppedEvent, strconv.FormatUint(dropped, 10)); err != nil {
+			return err
+		}
+	}
+	for _, line := range lines {
+		seq++
+		if err := writeEvent(w, id(seq), event, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEvent writes a single event with the given data.
+func writeEvent(w io.Writer, id, event, data string) error {
+	data = strings.ReplaceAll(data, "\r", "")
+	if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, data); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	return nil
+}
+
+// formatEventID formats an event id from the given cursors.
+func formatEventID(since, stderrSince uint64) string {
+	return strconv.FormatUint(since, 10) + "-" + strconv.FormatUint(stderrSince, 10)
+}
+
+var errInvalidEventID = errors.New("invalid event id")
+
+// parseEventID parses an event id into cursors.
+// An empty id is treated as both cursors being zero.
+func parseEventID(id string) (since, stderrSince uint64, err error) {
+	if id == "" {
+		return 0, 0, nil
+	}
+
+	sinceS, stderrSinceS, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, errInvalidEventID
+	}
+	if since, err = parseCursor(sinceS); err != nil {
+		return 0, 0, err
+	}
+	if stderrSince, err = parseCursor(stderrSinceS); err != nil {
+		return 0, 0, err
+	}
+	return since, stderrSince, nil
+}
@@ -0,0 +1,162 @@
+//spellchecker:words rest impl
+package rest_impl
+
+//spellchecker:words encoding json http strconv strings time github process over websocket internal history reject proto store
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/history"
+	"github.com/FAU-CDI/process_over_websocket/internal/reject"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/store"
+)
+
+// newSessionInfo describes the session recorded in record.
+func newSessionInfo(record *store.Record) proto.SessionInfo {
+	info := proto.SessionInfo{
+		ID:        record.ID,
+		Call:      record.Call.Call,
+		Params:    record.Call.Params,
+		Principal: record.Owner,
+		Started:   record.Started,
+		Finished:  record.Finished,
+
+		Status: history.Status(record),
+	}
+	if info.Params == nil {
+		info.Params = []string{}
+	}
+	if record.Result != nil {
+		info.Code, _ = proto.CodeOf(record.Result.Reason)
+	}
+	return info
+}
+
+// newSessionDetails returns the details of the session recorded in record.
+func newSessionDetails(record *store.Record) proto.SessionDetails {
+	return proto.SessionDetails{
+		SessionInfo: newSessionInfo(record),
+
+		Output:        strings.Join(record.Output.Lines, "\n"),
+		OutputDropped: record.Output.Total - uint64(len(record.Output.Lines)),
+
+		Stderr:        strings.Join(record.Stderr.Lines, "\n"),
+		StderrDropped: record.Stderr.Total - uint64(len(record.Stderr.Lines)),
+
+		Result: record.Result,
+	}
+}
+
+// serveSessions lists finished sessions in the history.
+//
+// Sessions started by an authenticated principal are only listed for the same principal.
+// Other sessions are only listed if session secrets are disabled.
+func (server *Server) serveSessions(w http.ResponseWriter, r *http.Request) {
+	if server.history == nil {
+		http.Error(w, "session history not enabled", http.StatusNotFound)
+		return
+	}
+
+	query, ok := parseSessionQuery(w, r)
+	if !ok {
+		return
+	}
+
+	principal := proto.PrincipalFrom(r.Context())
+	query.Visible = func(record *store.Record) bool {
+		if record.Owner != "" {
+			return principal != nil && principal.Name == record.Owner
+		}
+		return server.options.DisableSessionSecret
+	}
+
+	page, err := server.history.List(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list := proto.SessionList{Sessions: make([]proto.SessionInfo, len(page.Records)), Next: page.Next}
+	for i, record := range page.Records {
+		list.Sessions[i] = newSessionInfo(record)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list) //nolint:errchkjson
+}
+
+// parseSessionQuery parses the query parameters of a request to list sessions.
+// If a parameter is invalid, writes an error to w and returns ok = false.
+func parseSessionQuery(w http.ResponseWriter, r *http.Request) (query history.Query, ok bool) {
+	values := r.URL.Query()
+
+	query.Status = values.Get("status")
+	query.Call = values.Get("call")
+	query.Cursor = values.Get("cursor")
+
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{
+		{"after", &query.After},
+		{"before", &query.Before},
+	} {
+		value := values.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "invalid "+param.name+" time", http.StatusBadRequest)
+			return query, false
+		}
+		*param.dest = t
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return query, false
+		}
+		query.Limit = limit
+	}
+
+	return query, true
+}
+
+// serveSession returns the details of a finished session in the history.
+// The client must be authorized to access the session, see [Session.Authorize].
+func (server *Server) serveSession(w http.ResponseWriter, r *http.Request) {
+	if server.history == nil {
+		http.Error(w, "session history not enabled", http.StatusNotFound)
+		return
+	}
+
+	// extract the id from the path
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "did not provide id", http.StatusBadRequest)
+		return
+	}
+
+	// get the record
+	record, ok := server.history.Get(id)
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	// check that the client may access it
+	if err := authorize(record.Owner, record.SecretHash, proto.PrincipalFrom(r.Context()), sessionSecret(r), server.options.DisableSessionSecret); err != nil {
+		reject.Write(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newSessionDetails(record)) //nolint:errchkjson
+}
@@ -1,25 +1,34 @@
 //spellchecker:words rest impl
 package rest_impl
 
-//spellchecker:words context encoding json errors http sync time github process over websocket proto google uuid gorilla swaggest swgui pkglib httpx internal clean omap vapor embed
+//spellchecker:words context encoding json errors http strconv strings sync time github process over websocket proto google uuid gorilla swaggest swgui pkglib httpx internal clean history limit omap origin quota reject vapor store embed
 import (
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/store"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/swaggest/swgui/v5emb"
 	"go.tkw01536.de/pkglib/httpx"
 
 	"github.com/FAU-CDI/process_over_websocket/internal/clean"
+	"github.com/FAU-CDI/process_over_websocket/internal/history"
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
 	"github.com/FAU-CDI/process_over_websocket/internal/omap"
+	"github.com/FAU-CDI/process_over_websocket/internal/origin"
+	"github.com/FAU-CDI/process_over_websocket/internal/quota"
+	"github.com/FAU-CDI/process_over_websocket/internal/reject"
 	"github.com/FAU-CDI/process_over_websocket/internal/vapor"
 
 	_ "embed"
@@ -50,14 +59,58 @@ type Options struct {
 
 	// options for the session
 	Session SessionOpts
+
+	// Authenticator, if non-nil, authenticates all requests except those for the documentation.
+	Authenticator proto.Authenticator
+
+	// DisableSessionSecret allows clients to access sessions without passing their secret, see [proto.SessionSecretHeader].
+	// By default, the secret is required for all sessions not started by an authenticated principal.
+	// Sessions started by an authenticated principal can only be accessed by the same principal regardless of this setting.
+	DisableSessionSecret bool
+
+	// Origins configures which origins may access the server, see [origin.Options].
+	// By default, only same-origin requests are allowed.
+	Origins origin.Options
+
+	// Limiter, if non-nil, limits the number of processes running at once.
+	// Processes waiting for a slot report their position in the queue in their status.
+	Limiter *limit.Limiter
+
+	// Quota, if non-nil, limits the processes each client may start.
+	// Clients exceeding it are rejected with the 429 Too Many Requests status code.
+	Quota *quota.Quota
+
+	// Store, if non-nil, persists sessions so that their status remains available after the server restarts.
+	// Output of running sessions is persisted periodically, see [SessionOpts.SaveInterval].
+	Store store.Store
+
+	// Retention is the time sessions are kept in the Store after they finish.
+	// Sessions are removed from memory once they expire, but are restored from the Store after a restart until then.
+	// Defaults to 24 hours, and is never less than Timeout.
+	Retention time.Duration
+
+	// ErrorLog is used to log errors reading and removing sessions from the Store.
+	// If nil, errors are logged using the standard logger of the [log] package.
+	ErrorLog *log.Logger
+
+	// History configures the history of finished sessions, see [history.Options].
+	// It is disabled by default.
+	History history.Options
 }
 
-const minTimeout = time.Minute
+const (
+	minTimeout       = time.Minute
+	defaultRetention = 24 * time.Hour
+)
 
 func (opt *Options) SetDefaults() {
 	if opt.Timeout < minTimeout {
 		opt.Timeout = minTimeout
 	}
+	if opt.Retention <= 0 {
+		opt.Retention = defaultRetention
+	}
+	opt.Retention = max(opt.Retention, opt.Timeout)
 }
 
 //nolint:containedctx
@@ -68,8 +121,14 @@ type Server struct {
 	context context.Context
 	cancel  context.CancelCauseFunc
 
-	mux   http.ServeMux
-	vapor vapor.Vapor[Session]
+	mux     http.ServeMux
+	cors    http.Handler // mux wrapped to check the origin of requests
+	vapor   vapor.Vapor[Session]
+	history *history.History // finished sessions, nil if disabled
+	spec    []byte           // spec with server, set only when the handler implements [proto.ProcessLister]
+
+	retainedM sync.Mutex
+	retained  map[string]time.Time // ids of sessions in the store no longer in memory, and when they ended
 
 	path    string
 	options Options
@@ -81,6 +140,7 @@ func (server *Server) doInit() {
 		server.options.SetDefaults()
 
 		server.context, server.cancel = context.WithCancelCause(context.Background())
+		server.history = history.New(server.options.History)
 
 		server.vapor.NewID = func() string {
 			uuid, err := uuid.NewRandom()
@@ -90,28 +150,42 @@ func (server *Server) doInit() {
 			return uuid.String()
 		}
 		server.vapor.Initialize = func(s *Session) {
-			s.Init(server.handler, server.context, server.options.Session)
+			s.Init(server.context, server.options.Session, server.options.Store)
 		}
 		server.vapor.Finalize = func(fr vapor.FinalizeReason, s *Session) {
 			if fr == vapor.FinalizeReasonExpired {
 				s.CloseWith(proto.ErrCancelTimeout)
+				if server.options.Store != nil {
+					server.retain(s.Record())
+				}
 			}
 		}
+		server.restore()
 
 		base := clean.Clean(server.path)
 
-		server.mux.HandleFunc("POST "+base+"new", server.serveNew)
-		server.mux.HandleFunc("GET "+base+"status/{id}", server.serveStatus)
-		server.mux.HandleFunc("POST "+base+"input/{id}", server.serveInput)
-		server.mux.HandleFunc("POST "+base+"closeInput/{id}", server.serveCloseInput)
-		server.mux.HandleFunc("POST "+base+"cancel/{id}", server.serveCancel)
+		server.mux.HandleFunc("POST "+base+"new", server.authenticated(server.serveNew))
+		server.mux.HandleFunc("GET "+base+"status/{id}", server.authenticated(server.serveStatus))
+		server.mux.HandleFunc("GET "+base+"events/{id}", server.authenticated(server.serveEvents))
+		server.mux.HandleFunc("GET "+base+"wait/{id}", server.authenticated(server.serveWait))
+		server.mux.HandleFunc("POST "+base+"input/{id}", server.authenticated(server.serveInput))
+		server.mux.HandleFunc("POST "+base+"closeInput/{id}", server.authenticated(server.serveCloseInput))
+		server.mux.HandleFunc("POST "+base+"cancel/{id}", server.authenticated(server.serveCancel))
+		server.mux.HandleFunc("GET "+base+"processes", server.authenticated(server.serveProcesses))
+		server.mux.HandleFunc("GET "+base+"sessions", server.authenticated(server.serveSessions))
+		server.mux.HandleFunc("GET "+base+"sessions/{id}", server.authenticated(server.serveSession))
 
 		// format the openapi.json spec to contain the appropriate base path
 		spec, err := getSpecWithServer(specJSON, base, server.options.OpenAPIServerDescription)
 		if err != nil {
 			panic("failed to get spec with server (shouldn't happen): " + err.Error())
 		}
-		server.mux.Handle("GET "+base+"openapi.json", &httpx.Response{ContentType: "application/json", Body: spec})
+		if _, ok := server.handler.(proto.ProcessLister); ok {
+			server.spec = spec
+			server.mux.HandleFunc("GET "+base+"openapi.json", server.serveSpec)
+		} else {
+			server.mux.Handle("GET "+base+"openapi.json", &httpx.Response{ContentType: "application/json", Body: spec})
+		}
 
 		// serve the api docs
 		if !server.options.DisableSwaggerUI {
@@ -121,9 +195,96 @@ func (server *Server) doInit() {
 				base+"docs/",
 			))
 		}
+
+		server.cors = server.options.Origins.CORS(&server.mux)
 	})
 }
 
+// restore restores the sessions persisted in the store, if any, and adds them to the history.
+// Sessions past their retention are removed from the store instead.
+func (server *Server) restore() {
+	if server.options.Store == nil {
+		return
+	}
+
+	records, err := server.options.Store.List()
+	if err != nil {
+		server.logf("failed to list stored sessions: %v", err)
+	}
+
+	var expired []*store.Record
+	deadline := time.Now().Add(-server.options.Retention)
+	for _, record := range records {
+		if ended(record).Before(deadline) {
+			expired = append(expired, record)
+			continue
+		}
+
+		session, err := server.vapor.GetNewWithID(record.ID, server.options.Timeout)
+		if err != nil {
+			server.logf("failed to restore session %q: %v", record.ID, err)
+			continue
+		}
+		if err := session.Restore(record); err != nil {
+			server.logf("failed to restore session %q: %v", record.ID, err)
+		}
+		server.history.Add(session.Record())
+	}
+	server.retain(expired...)
+}
+
+// ended returns the time the session recorded in record ended.
+// Sessions interrupted by a restart are considered to have ended when they started.
+func ended(record *store.Record) time.Time {
+	if record.Finished.IsZero() {
+		return record.Started
+	}
+	return record.Finished
+}
+
+// retain keeps the given records of sessions no longer in memory in the store until their retention has passed.
+// It then removes all records past their retention from the store.
+func (server *Server) retain(records ...*store.Record) {
+	deadline := time.Now().Add(-server.options.Retention)
+
+	var remove []string
+	func() {
+		server.retainedM.Lock()
+		defer server.retainedM.Unlock()
+
+		if server.retained == nil {
+			server.retained = make(map[string]time.Time)
+		}
+		for _, record := range records {
+			if record.ID == "" { // session was never started
+				continue
+			}
+			server.retained[record.ID] = ended(record)
+		}
+		for id, end := range server.retained {
+			if end.Before(deadline) {
+				remove = append(remove, id)
+				delete(server.retained, id)
+			}
+		}
+	}()
+
+	for _, id := range remove {
+		if err := server.options.Store.Delete(id); err != nil {
+			server.logf("failed to remove stored session %q: %v", id, err)
+		}
+	}
+}
+
+// logf logs an error using the logger of the server, see [Options.ErrorLog].
+func (server *Server) logf(format string, args ...any) {
+	if server.options.ErrorLog != nil {
+		server.options.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
 // getSpecWithServer parses the spec, and place a single server url pointing to server.
 // If parsing fails, returns the original spec and an error.
 func getSpecWithServer(spec []byte, server, description string) ([]byte, error) {
@@ -155,6 +316,63 @@ func getSpecWithServer(spec []byte, server, description string) ([]byte, error)
 	return result, nil
 }
 
+// getSpecWithProcesses parses the spec, and documents the given processes as possible calls.
+// If parsing fails, returns the original spec and an error.
+func getSpecWithProcesses(spec []byte, processes []proto.ProcessInfo) ([]byte, error) {
+	names := make([]string, len(processes))
+
+	var description strings.Builder
+	description.WriteString("Name of the process to start. \n\nAvailable processes:\n")
+	for i, process := range processes {
+		names[i] = process.Name
+
+		description.WriteString("\n- `" + process.Name + "`")
+		for _, param := range process.Params {
+			description.WriteString(" `" + param.Name + "`")
+		}
+		if process.Variadic {
+			description.WriteString(" ...")
+		}
+		if process.Description != "" {
+			description.WriteString(": " + process.Description)
+		}
+	}
+
+	namesBytes, err := json.Marshal(names)
+	if err != nil {
+		return spec, fmt.Errorf("failed to marshal names: %w", err)
+	}
+	descriptionBytes, err := json.Marshal(description.String())
+	if err != nil {
+		return spec, fmt.Errorf("failed to marshal description: %w", err)
+	}
+
+	var parsed omap.OrderedMap
+
+	// decode the json
+	if err := json.Unmarshal(spec, &parsed); err != nil || parsed == nil {
+		return spec, fmt.Errorf("failed to parse openapi spec: %w", err)
+	}
+
+	// update the call property
+	callPath := []string{"paths", "/new", "post", "requestBody", "content", "application/json", "schema", "properties", "call"}
+	if len(names) > 0 {
+		if err := parsed.SetPath(namesBytes, append(callPath, "enum")...); err != nil {
+			return spec, fmt.Errorf("failed to set enum: %w", err)
+		}
+	}
+	if err := parsed.SetPath(descriptionBytes, append(callPath, "description")...); err != nil {
+		return spec, fmt.Errorf("failed to set description: %w", err)
+	}
+
+	// and re-marshal
+	result, err := json.Marshal(parsed)
+	if err != nil {
+		return spec, fmt.Errorf("failed to marshal openapi spec (shouldn't happen): %w", err)
+	}
+	return result, nil
+}
+
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	server.doInit()
 
@@ -171,7 +389,46 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// serve the server
-	server.mux.ServeHTTP(w, r)
+	server.cors.ServeHTTP(w, r)
+}
+
+// authenticated wraps handler to only be called for authenticated requests, see [Options.Authenticator].
+func (server *Server) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = reject.Authenticate(w, r, server.options.Authenticator)
+		if r == nil {
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (server *Server) serveSpec(w http.ResponseWriter, r *http.Request) {
+	spec := server.spec
+
+	// document the processes available to the client
+	if lister, ok := server.handler.(proto.ProcessLister); ok {
+		spec, _ = getSpecWithProcesses(spec, lister.Processes(r))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(spec)
+}
+
+func (server *Server) serveProcesses(w http.ResponseWriter, r *http.Request) {
+	lister, ok := server.handler.(proto.ProcessLister)
+	if !ok {
+		http.Error(w, "listing processes not supported", http.StatusNotFound)
+		return
+	}
+
+	processes := lister.Processes(r)
+	if processes == nil {
+		processes = []proto.ProcessInfo{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(processes) //nolint:errchkjson
 }
 
 func (server *Server) serveNew(w http.ResponseWriter, r *http.Request) {
@@ -182,17 +439,53 @@ func (server *Server) serveNew(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// find the right process
+	process, err := server.handler.Get(r, call.Call, call.Params...)
+	if err != nil {
+		reject.Write(w, fmt.Errorf("failed to get process: %w", err))
+		return
+	}
+
+	// check the quota of the client
+	release, err := server.options.Quota.Acquire(r)
+	if err != nil {
+		reject.Write(w, fmt.Errorf("failed to start process: %w", err))
+		return
+	}
+
+	// reserve a slot, or a place in the queue
+	ticket, err := server.options.Limiter.Reserve(r, call)
+	if err != nil {
+		release()
+		reject.Write(w, fmt.Errorf("failed to start process: %w", err))
+		return
+	}
+
 	// create the new element
 	id, session, err := server.vapor.GetNew(server.options.Timeout)
 	if err != nil {
+		ticket.Release()
+		release()
 		http.Error(w, "failed to create new process", http.StatusInternalServerError)
 		return
 	}
 
-	// start the session
-	session.Start(r, call)
-
-	// return the new id to the client
+	// start the session, and give back the quota and remember it once it is done
+	if err := session.Start(id, r, process, call, ticket); err != nil {
+		server.vapor.Evict(id)
+		ticket.Release()
+		release()
+		http.Error(w, "failed to start process", http.StatusInternalServerError)
+		return
+	}
+	go func() {
+		<-session.Done()
+		release()
+		server.history.Add(session.Record())
+	}()
+
+	// return the new id and secret to the client
+	w.Header().Set(proto.SessionSecretHeader, session.Secret())
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(id) //nolint:errchkjson
 }
@@ -212,9 +505,130 @@ func (server *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check that the client may access it
+	if !server.authorize(w, r, session) {
+		return
+	}
+
+	// parse the cursors
+	since, stderrSince, ok := parseCursors(w, r)
+	if !ok {
+		return
+	}
+
 	// marshal the status into the response
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(session.Status()) //nolint:errchkjson
+	_ = json.NewEncoder(w).Encode(session.Status(since, stderrSince)) //nolint:errchkjson
+}
+
+// authorize checks that the client making r may access session, see [Session.Authorize].
+// If not, writes an error to w and returns false.
+func (server *Server) authorize(w http.ResponseWriter, r *http.Request, session *Session) bool {
+	if err := session.Authorize(proto.PrincipalFrom(r.Context()), sessionSecret(r), server.options.DisableSessionSecret); err != nil {
+		reject.Write(w, err)
+		return false
+	}
+	return true
+}
+
+// sessionSecret returns the secret passed along with r, see [proto.SessionSecretHeader].
+func sessionSecret(r *http.Request) string {
+	if secret := r.Header.Get(proto.SessionSecretHeader); secret != "" {
+		return secret
+	}
+	return r.URL.Query().Get("secret")
+}
+
+// defaultWaitTimeout is the default timeout for the wait endpoint.
+const defaultWaitTimeout = 30 * time.Second
+
+func (server *Server) serveWait(w http.ResponseWriter, r *http.Request) {
+	// extract the id from the path
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "did not provide id", http.StatusBadRequest)
+		return
+	}
+
+	// get the session
+	session, err := server.vapor.Get(id)
+	if err != nil {
+		http.Error(w, "process not found", http.StatusNotFound)
+		return
+	}
+
+	// check that the client may access it
+	if !server.authorize(w, r, session) {
+		return
+	}
+
+	// parse the cursors
+	since, stderrSince, ok := parseCursors(w, r)
+	if !ok {
+		return
+	}
+
+	// parse the timeout
+	timeout := defaultWaitTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// don't wait long enough for the session to expire
+	timeout = min(timeout, server.options.Timeout/2)
+
+	// wait for the process to finish, or the timeout to pass
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	_, _ = session.Wait(ctx)
+
+	// the client has gone away
+	if r.Context().Err() != nil {
+		return
+	}
+
+	// keep the session from expiring
+	if _, err := server.vapor.Get(id); err != nil {
+		http.Error(w, "process not found", http.StatusNotFound)
+		return
+	}
+
+	// marshal the status into the response
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(session.Status(since, stderrSince)) //nolint:errchkjson
+}
+
+// parseCursors parses the since and stderrSince query parameters of r.
+// If a cursor is invalid, writes an error to w and returns ok = false.
+func parseCursors(w http.ResponseWriter, r *http.Request) (since, stderrSince uint64, ok bool) {
+	since, err := parseCursor(r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, "invalid since cursor", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	stderrSince, err = parseCursor(r.URL.Query().Get("stderrSince"))
+	if err != nil {
+		http.Error(w, "invalid stderrSince cursor", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return since, stderrSince, true
+}
+
+// parseCursor parses a cursor passed as a query parameter.
+// An empty cursor is treated as zero.
+func parseCursor(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	cursor, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse cursor: %w", err)
+	}
+	return cursor, nil
 }
 
 func (server *Server) serveInput(w http.ResponseWriter, r *http.Request) {
@@ -232,6 +646,11 @@ func (server *Server) serveInput(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check that the client may access it
+	if !server.authorize(w, r, session) {
+		return
+	}
+
 	// copy the body over
 	if _, err := io.Copy(session, r.Body); err != nil {
 		http.Error(w, "error copying data to process", http.StatusInternalServerError)
@@ -258,6 +677,11 @@ func (server *Server) serveCloseInput(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check that the client may access it
+	if !server.authorize(w, r, session) {
+		return
+	}
+
 	// Close it's input
 	if err := session.CloseInput(); err != nil {
 		http.Error(w, "error closing input", http.StatusInternalServerError)
@@ -284,6 +708,11 @@ func (server *Server) serveCancel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check that the client may access it
+	if !server.authorize(w, r, session) {
+		return
+	}
+
 	// close the session
 	session.CloseWith(proto.ErrCancelClientRequest)
 
@@ -292,6 +721,27 @@ func (server *Server) serveCancel(w http.ResponseWriter, r *http.Request) {
 	_, _ = io.WriteString(w, "process cancelled")
 }
 
+var errSessionNotFound = errors.New("process not found")
+
+// Session returns the session with the given id and keeps it from expiring.
+// The client making r must be authorized to access the session using the given secret, see [Session.Authorize].
+// A nil server has no sessions.
+func (server *Server) Session(r *http.Request, id, secret string) (*Session, error) {
+	if server == nil {
+		return nil, errSessionNotFound
+	}
+	server.doInit()
+
+	session, err := server.vapor.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errSessionNotFound, err)
+	}
+	if err := session.Authorize(proto.PrincipalFrom(r.Context()), secret, server.options.DisableSessionSecret); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
 var errServerClose = errors.New("server closing")
 
 func (server *Server) Close() {
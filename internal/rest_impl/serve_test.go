@@ -0,0 +1,278 @@
+//spellchecker:words rest impl
+package rest_impl_test
+
+//spellchecker:words bufio context encoding json http httptest slices strings testing time github process over websocket internal rest impl proto
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/rest_impl"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// testHandler provides processes used by the tests.
+var testHandler = proto.HandlerFunc(func(r *http.Request, name string, args ...string) (proto.Process, error) {
+	switch name {
+	case "lines":
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			for _, arg := range args {
+				if _, err := fmt.Fprintln(output, arg); err != nil {
+					return nil, fmt.Errorf("failed to write: %w", err)
+				}
+			}
+			return len(args), nil
+		}), nil
+	case "block":
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			for _, arg := range args {
+				if _, err := fmt.Fprintln(output, arg); err != nil {
+					return nil, fmt.Errorf("failed to write: %w", err)
+				}
+			}
+			<-ctx.Done()
+			return nil, context.Cause(ctx)
+		}), nil
+	}
+	return nil, proto.ErrHandlerUnknownProcess
+})
+
+// newTestServer starts a new rest server for testing.
+func newTestServer(t *testing.T, handler proto.Handler) *httptest.Server {
+	t.Helper()
+
+	server := rest_impl.NewServer("/", handler, rest_impl.Options{DisableSwaggerUI: true})
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+	return httpServer
+}
+
+// startSession starts a new session on the server and returns its id and secret.
+func startSession(t *testing.T, server *httptest.Server, call proto.CallMessage) (id, secret string) {
+	t.Helper()
+
+	body, err := json.Marshal(call)
+	if err != nil {
+		t.Fatalf("failed to encode call: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, server.URL+"/new", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if err := json.NewDecoder(res.Body).Decode(&id); err != nil {
+		t.Fatalf("failed to decode id: %v", err)
+	}
+	return id, res.Header.Get(proto.SessionSecretHeader)
+}
+
+type event struct {
+	ID, Event, Data string
+}
+
+// readEvents requests the event stream of the given session and reads it until it ends.
+func readEvents(t *testing.T, server *httptest.Server, id, secret, lastEventID string) []event {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL+"/events/"+id, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set(proto.SessionSecretHeader, secret)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to get events: %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q, want %q", ct, "text/event-stream")
+	}
+
+	var (
+		events  []event
+		current event
+	)
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch key {
+		case "id":
+			current.ID = value
+		case "event":
+			current.Event = value
+		case "data":
+			current.Data = value
+		case "":
+			events = append(events, current)
+			current = event{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	return events
+}
+
+func TestServer_events(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t, testHandler)
+	id, secret := startSession(t, server, proto.CallMessage{Call: "lines", Params: []string{"a", "b"}})
+
+	result := event{ID: "2-0", Event: "result", Data: `{"status":"fulfilled","value":2}`}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []event
+	}{
+		{
+			name: "all",
+			want: []event{
+				{ID: "1-0", Event: "output", Data: "a"},
+				{ID: "2-0", Event: "output", Data: "b"},
+				result,
+			},
+		},
+		{
+			name:        "resume",
+			lastEventID: "1-0",
+			want: []event{
+				{ID: "2-0", Event: "output", Data: "b"},
+				result,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := readEvents(t, server, id, secret, tt.lastEventID); !slices.Equal(got, tt.want) {
+				t.Errorf("got events %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// getStatus makes a get request to the given path, passing along secret, and decodes the returned status.
+func getStatus(t *testing.T, server *httptest.Server, path, secret string) proto.Status {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set(proto.SessionSecretHeader, secret)
+
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status code %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	var status proto.Status
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	return status
+}
+
+func TestServer_wait(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t, testHandler)
+
+	t.Run("finished", func(t *testing.T) {
+		t.Parallel()
+
+		id, secret := startSession(t, server, proto.CallMessage{Call: "lines", Params: []string{"a", "b"}})
+
+		status := getStatus(t, server, "/wait/"+id+"?timeout=5s", secret)
+		if status.Result == nil || status.Buffer != "a\nb" {
+			t.Errorf("got result %v and buffer %q, want finished result and %q", status.Result, status.Buffer, "a\nb")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		id, secret := startSession(t, server, proto.CallMessage{Call: "block"})
+
+		start := time.Now()
+		status := getStatus(t, server, "/wait/"+id+"?timeout=100ms", secret)
+		if status.Result != nil {
+			t.Errorf("got result %v, want pending", status.Result)
+		}
+		if took := time.Since(start); took < 100*time.Millisecond {
+			t.Errorf("wait returned after %v, want at least 100ms", took)
+		}
+	})
+}
+
+func TestServer_secret(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t, testHandler)
+	id, secret := startSession(t, server, proto.CallMessage{Call: "block"})
+	if secret == "" {
+		t.Fatal("did not receive a secret")
+	}
+
+	// the secret is required by default
+	for _, tt := range []struct {
+		name   string
+		secret string
+		want   int
+	}{
+		{"no secret", "", http.StatusForbidden},
+		{"wrong secret", "wrong", http.StatusForbidden},
+		{"secret", secret, http.StatusOK},
+	} {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL+"/status/"+id, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if tt.secret != "" {
+			req.Header.Set(proto.SessionSecretHeader, tt.secret)
+		}
+
+		res, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("failed to get status: %v", err)
+		}
+		_ = res.Body.Close()
+
+		if res.StatusCode != tt.want {
+			t.Errorf("%s: got status code %d, want %d", tt.name, res.StatusCode, tt.want)
+		}
+	}
+}
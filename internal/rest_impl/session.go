@@ -1,18 +1,26 @@
 //spellchecker:words rest impl
 package rest_impl
 
-//spellchecker:words context encoding json errors http sync github process over websocket internal finbuf proto pkglib recovery
+//spellchecker:words context crypto rand sha256 subtle errors http strings sync atomic time github process over websocket internal coalesce finbuf limit proto store pkglib recovery
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/FAU-CDI/process_over_websocket/internal/coalesce"
 	"github.com/FAU-CDI/process_over_websocket/internal/finbuf"
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
 	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/store"
 	"go.tkw01536.de/pkglib/recovery"
 )
 
@@ -24,11 +32,34 @@ type Session struct {
 	m     sync.RWMutex
 	stage stage
 
-	// handler and call hold the original call
+	// id is the id of this session, and store persists it if non-nil
+	id    string
+	store store.Store
+
+	// snapshots counts the records taken of this session, see [Session.snapshot].
+	// saveM protects writing them to the store, and saved is the number of the newest record written.
+	snapshots atomic.Uint64
+	saveM     sync.Mutex
+	saved     uint64
+
+	// process and call hold the original call
 	// used to initiate this session
-	handler proto.Handler
+	process proto.Process
 	call    proto.CallMessage
 
+	// ticket holds the slot to run the process in, or its place in the queue.
+	ticket *limit.Ticket
+
+	// owner is the principal that started this session, if any.
+	// secret is a random secret returned to whoever started the session, and secretHash its hash.
+	// Restored sessions only know the hash of their secret.
+	owner      *proto.Principal
+	secret     string
+	secretHash [sha256.Size]byte
+
+	// started and finished are the times the process was started and returned
+	started, finished time.Time
+
 	// context and cancel can be used to cancel the underlying process
 	context context.Context
 	cancel  context.CancelCauseFunc
@@ -43,6 +74,15 @@ type Session struct {
 	// out holds the output of this session
 	out finbuf.FiniteBuffer
 
+	// errOut holds the error output of this session
+	errOut finbuf.FiniteBuffer
+
+	// coalesce configures batching of writes to out and errOut
+	coalesce coalesce.Options
+
+	// saveInterval is the minimal interval between persisting new output of the running session
+	saveInterval time.Duration
+
 	// result of the process
 	result any
 	err    error
@@ -58,22 +98,41 @@ const (
 
 type SessionOpts struct {
 	MaxLines int
+
+	// Coalesce configures batching of output written by processes before it is added to the buffers.
+	Coalesce coalesce.Options
+
+	// SaveInterval is the minimal interval between persisting new output of running sessions, if they are persisted at all.
+	// This keeps their output available if the server crashes.
+	// Defaults to 5 seconds.
+	SaveInterval time.Duration
 }
 
-const minMaxLines = 1000
+const (
+	minMaxLines         = 1000
+	defaultSaveInterval = 5 * time.Second
+)
 
 func (opt *SessionOpts) SetDefaults() {
 	if opt.MaxLines < minMaxLines {
 		opt.MaxLines = minMaxLines
 	}
+	if opt.SaveInterval <= 0 {
+		opt.SaveInterval = defaultSaveInterval
+	}
 }
 
 // Init initializes this session, preparing it for accepting a new session.
-func (session *Session) Init(handler proto.Handler, ctx context.Context, opt SessionOpts) {
+// If st is non-nil, the session is persisted in it once started.
+func (session *Session) Init(ctx context.Context, opt SessionOpts, st store.Store) {
 	opt.SetDefaults()
 
+	session.store = st
+
 	session.out.MaxLines = opt.MaxLines
-	session.handler = handler
+	session.errOut.MaxLines = opt.MaxLines
+	session.coalesce = opt.Coalesce
+	session.saveInterval = opt.SaveInterval
 
 	session.context, session.cancel = context.WithCancelCause(ctx)
 	session.done = make(chan struct{})
@@ -81,27 +140,213 @@ func (session *Session) Init(handler proto.Handler, ctx context.Context, opt Ses
 	session.inr, session.inw = io.Pipe()
 }
 
-// Start starts the given call in this session.
-func (session *Session) Start(r *http.Request, call proto.CallMessage) bool {
+var errSessionStarted = errors.New("session already started")
+
+// Start starts the given process, obtained from the handler for call, in this session with the given id.
+// The principal making the request r, if any, is made available to the process, see [proto.PrincipalFrom].
+//
+// The process waits until ticket holds a slot, and releases it once it returns.
+// If the session can not be persisted, it is not started and an error is returned.
+func (session *Session) Start(id string, r *http.Request, process proto.Process, call proto.CallMessage, ticket *limit.Ticket) error {
 	session.m.Lock()
 	defer session.m.Unlock()
 
 	// we only work in the initial stage
 	if session.stage != stageInit {
-		return false
+		return errSessionStarted
+	}
+
+	session.id = id
+	session.process = process
+	session.call = call
+	session.ticket = ticket
+	session.secret = rand.Text()
+	session.secretHash = sha256.Sum256([]byte(session.secret))
+	session.started = time.Now()
+	session.owner = proto.PrincipalFrom(r.Context())
+	if session.owner != nil {
+		session.context = proto.WithPrincipal(session.context, session.owner)
+	}
+
+	// persist the session before running it, so that it is known to be interrupted after a restart
+	if err := session.save(); err != nil {
+		session.cancel(err)
+		return err
 	}
 
 	// and we're now in the running stage
 	session.stage = stageRunning
-	session.call = call
-	go session.run(r)
+	if session.store != nil {
+		go session.checkpoint(session.out.Changed(), session.errOut.Changed())
+	}
+	go session.run()
+
+	return nil
+}
+
+var errInterrupted = fmt.Errorf("%w: process was interrupted", proto.ErrServerRestarted)
+
+// Restore restores a session persisted by a previous server from record.
+// The restored session has finished; if the process was still running, it is rejected with an error wrapping [proto.ErrServerRestarted].
+func (session *Session) Restore(record *store.Record) error {
+	session.m.Lock()
+	defer session.m.Unlock()
+
+	if session.stage != stageInit {
+		return errSessionStarted
+	}
+
+	session.id = record.ID
+	session.call = record.Call
+	copy(session.secretHash[:], record.SecretHash)
+	if record.Owner != "" {
+		session.owner = &proto.Principal{Name: record.Owner}
+	}
+	session.started = record.Started
+	session.finished = record.Finished
+
+	session.out.Restore(record.Output.Lines, record.Output.Total)
+	session.errOut.Restore(record.Stderr.Lines, record.Stderr.Total)
+
+	if record.Result != nil {
+		session.result = record.Result.Value
+		session.err = record.Result.Reason
+	} else {
+		session.err = errInterrupted
+	}
+
+	session.stage = stageFinished
+	session.cancel(proto.ErrCancelHandlerReturn)
+	_ = session.closeInputs()
+	close(session.done)
+
+	// remember that the process was interrupted
+	if record.Result == nil {
+		return session.save()
+	}
+	return nil
+}
+
+// ID returns the id of this session.
+// It is empty if the session has not been started.
+func (session *Session) ID() string {
+	session.m.RLock()
+	defer session.m.RUnlock()
+
+	return session.id
+}
+
+// save persists the current state of this session in its store, if any.
+// session.m must be held.
+func (session *Session) save() error {
+	if session.store == nil {
+		return nil
+	}
+	return session.saveRecord(session.snapshot())
+}
+
+// snapshot returns a record of the current state of this session to be persisted, along with its number.
+// Records are numbered in the order they are taken, so that older records never replace newer ones in the store.
+// session.m must be held.
+func (session *Session) snapshot() (*store.Record, uint64) {
+	// processes cancelled because the server is closing are reported as interrupted once it restarts
+	record := session.record()
+	if errors.Is(context.Cause(session.context), errServerClose) {
+		record.Result = nil
+	}
+	return record, session.snapshots.Add(1)
+}
+
+// saveRecord persists the record with the given number in the store of this session, unless a newer record was saved already.
+// session.m need not be held.
+func (session *Session) saveRecord(record *store.Record, number uint64) error {
+	session.saveM.Lock()
+	defer session.saveM.Unlock()
+
+	if number <= session.saved {
+		return nil
+	}
+	if err := session.store.Save(record); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	session.saved = number
+	return nil
+}
+
+// checkpoint persists new output of the running session at most once per save interval, so that it survives a crash of the server.
+// out and errOut are the channels of the buffers notifying about output written after the session was last saved.
+// It returns once the process has returned, which persists the session itself.
+func (session *Session) checkpoint(out, errOut <-chan struct{}) {
+	for {
+		// wait for new output
+		select {
+		case <-session.done:
+			return
+		case <-out:
+		case <-errOut:
+		}
+
+		// and gather any more written within the interval
+		select {
+		case <-session.done:
+			return
+		case <-time.After(session.saveInterval):
+		}
+
+		// take a snapshot under the lock, but write it without holding it
+		var (
+			record *store.Record
+			number uint64
+		)
+		func() {
+			session.m.RLock()
+			defer session.m.RUnlock()
+
+			out, errOut = session.out.Changed(), session.errOut.Changed()
+			if session.stage == stageRunning {
+				record, number = session.snapshot()
+			}
+		}()
+		if record != nil {
+			_ = session.saveRecord(record, number)
+		}
+	}
+}
+
+// record returns a record of the current state of this session.
+// session.m must be held.
+func (session *Session) record() *store.Record {
+	record := &store.Record{
+		ID:         session.id,
+		Call:       session.call,
+		SecretHash: session.secretHash[:],
+		Started:    session.started,
+		Finished:   session.finished,
+	}
+	if session.owner != nil {
+		record.Owner = session.owner.Name
+	}
+
+	record.Output.Lines, record.Output.Total, _ = session.out.Since(0)
+	record.Stderr.Lines, record.Stderr.Total, _ = session.errOut.Since(0)
+
+	if session.stage == stageFinished {
+		record.Result = &proto.Result{Value: session.result, Reason: session.err}
+	}
+	return record
+}
+
+// Record returns a record of the current state of this session.
+func (session *Session) Record() *store.Record {
+	session.m.RLock()
+	defer session.m.RUnlock()
 
-	return true
+	return session.record()
 }
 
 var errPanic = errors.New("panic() in process")
 
-func (session *Session) run(r *http.Request) {
+func (session *Session) run() {
 	var res any
 	var err = errPanic
 
@@ -111,30 +356,102 @@ func (session *Session) run(r *http.Request) {
 			err = e
 		}
 
-		session.m.Lock()
-		defer session.m.Unlock()
-
-		session.result = res
-		session.err = err
-		session.stage = stageFinished
+		var (
+			record *store.Record
+			number uint64
+		)
+		func() {
+			session.m.Lock()
+			defer session.m.Unlock()
+
+			session.result = res
+			session.err = err
+			session.stage = stageFinished
+			session.finished = time.Now()
+
+			if session.store != nil {
+				record, number = session.snapshot()
+			}
+		}()
+
+		// the result remains available until the server restarts even if it can not be persisted
+		if record != nil {
+			_ = session.saveRecord(record, number)
+		}
 	}()
 	defer session.cancel(proto.ErrCancelHandlerReturn)
 	defer func() { _ = session.inw.Close() }()
 
 	res, err = func() (any, error) {
-		// get the handler
-		process, err := session.handler.Get(r, session.call.Call, session.call.Params...)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get process: %w", err)
+		// wait for a slot
+		defer session.ticket.Release()
+		if err := session.ticket.Wait(session.context, nil); err != nil {
+			return nil, err
 		}
 
-		// and do the call
-		return process.Do(session.context, session.inr, &session.out, session.call.Params...)
+		// do the call
+		coalescer := coalesce.New(session.coalesce)
+		defer func() { _ = coalescer.Close() }()
+
+		return proto.Do(session.context, session.process, session.inr, coalescer.Writer(&session.out), coalescer.Writer(&session.errOut), session.call.Params...)
 	}()
 }
 
+// Secret returns the secret of this session.
+// It is empty if the session has not been started.
+func (session *Session) Secret() string {
+	session.m.RLock()
+	defer session.m.RUnlock()
+
+	return session.secret
+}
+
+var errSessionForbidden = fmt.Errorf("%w: process belongs to a different client", proto.ErrHandlerAuthorizationDenied)
+
+// Authorize checks that the given principal and secret may access this session.
+//
+// If the session was started by a principal, only the same principal may access it.
+// Otherwise, the secret must match the secret of the session, unless skipSecret is true.
+// If access is denied, returns an error wrapping [proto.ErrHandlerAuthorizationDenied].
+func (session *Session) Authorize(principal *proto.Principal, secret string, skipSecret bool) error {
+	session.m.RLock()
+	defer session.m.RUnlock()
+
+	var owner string
+	if session.owner != nil {
+		owner = session.owner.Name
+	}
+	return authorize(owner, session.secretHash[:], principal, secret, skipSecret)
+}
+
+// authorize checks that the given principal and secret may access a session started by the principal named owner, see [Session.Authorize].
+// An empty owner indicates that the session was not started by a principal.
+func authorize(owner string, secretHash []byte, principal *proto.Principal, secret string, skipSecret bool) error {
+	if owner != "" {
+		if principal == nil || principal.Name != owner {
+			return errSessionForbidden
+		}
+		return nil
+	}
+	if hash := sha256.Sum256([]byte(secret)); !skipSecret && subtle.ConstantTimeCompare(hash[:], secretHash) != 1 {
+		return errSessionForbidden
+	}
+	return nil
+}
+
 // CloseInput closes the input of the session.
+// The process reads any remaining input, followed by [io.EOF].
+// Any further writes fail, but unlike closing the session, reads of the process are not interrupted.
 func (session *Session) CloseInput() error {
+	if err := session.inw.Close(); err != nil {
+		return fmt.Errorf("failed to close input: %w", err)
+	}
+	return nil
+}
+
+// closeInputs closes both ends of the input pipe.
+// Any pending writes or reads return immediately.
+func (session *Session) closeInputs() error {
 	return errors.Join(
 		session.inw.Close(),
 		session.inr.Close(),
@@ -149,6 +466,28 @@ func (session *Session) Write(data []byte) (int, error) {
 	return n, nil
 }
 
+// Changed returns channels that are closed once the next line of output or error output is written respectively.
+func (session *Session) Changed() (output, stderr <-chan struct{}) {
+	return session.out.Changed(), session.errOut.Changed()
+}
+
+// Queued returns the position of the process in the queue, or 0 if it is not waiting to start.
+// It also returns a channel that is closed once the position changes.
+func (session *Session) Queued() (position int, changed <-chan struct{}) {
+	session.m.RLock()
+	defer session.m.RUnlock()
+
+	if session.stage != stageRunning {
+		return 0, nil
+	}
+	return session.ticket.State()
+}
+
+// Done returns a channel that is closed once the process has returned.
+func (session *Session) Done() <-chan struct{} {
+	return session.done
+}
+
 // Wait waits for this session to complete, and then returns it's result and error.
 // If context closes before the session is completed, immediately returns the context's error.
 func (session *Session) Wait(ctx context.Context) (result any, err error) {
@@ -170,7 +509,7 @@ func (session *Session) CloseWith(err error) {
 		if session.cancel != nil {
 			session.cancel(err)
 		}
-		_ = session.CloseInput() // TODO: not sure what to do with this error
+		_ = session.closeInputs() // TODO: not sure what to do with this error
 	}()
 
 	<-session.done
@@ -195,55 +534,35 @@ func (session *Session) Stage() (running, started bool) {
 	panic("never reached")
 }
 
-type Status struct {
-	Buffer string
-	Result *proto.Result
-}
-
-type statusJSON struct {
-	Buffer string          `json:"buffer,omitempty"`
-	Result json.RawMessage `json:"result"`
-}
-
-func (status Status) MarshalJSON() ([]byte, error) {
-	var data statusJSON
-	var err error
-
-	data.Buffer = status.Buffer
-	data.Result, err = status.Result.MarshalJSON()
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal result as json: %w", err)
-	}
-
-	res, err := json.Marshal(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal data: %w", err)
-	}
-	return res, nil
-}
-
 // Status returns the status.
-func (session *Session) Status() Status {
+// It only includes lines of output and error output with a sequence number greater than since and stderrSince respectively.
+func (session *Session) Status(since, stderrSince uint64) proto.Status {
 	session.m.RLock()
 	defer session.m.RUnlock()
 
+	var status proto.Status
 	switch session.stage {
 	case stageInit:
-		return Status{}
+		return status
 	case stageRunning:
-		return Status{
-			Result: nil,
-			Buffer: session.out.String(),
-		}
+		status.Result = nil
+		status.Queued, _ = session.ticket.State()
 	case stageFinished:
-		return Status{
-			Result: &proto.Result{
-				Value:  session.result,
-				Reason: session.err,
-			},
-			Buffer: session.out.String(),
+		status.Result = &proto.Result{
+			Value:  session.result,
+			Reason: session.err,
 		}
+	default:
+		panic("never reached")
 	}
 
-	panic("never reached")
+	var lines []string
+
+	lines, status.Cursor, status.Dropped = session.out.Since(since)
+	status.Buffer = strings.Join(lines, "\n")
+
+	lines, status.StderrCursor, status.StderrDropped = session.errOut.Since(stderrSince)
+	status.Stderr = strings.Join(lines, "\n")
+
+	return status
 }
@@ -0,0 +1,238 @@
+//spellchecker:words rest impl
+package rest_impl_test
+
+//spellchecker:words context crypto sha256 errors http httptest slices sync testing time github process over websocket internal limit rest impl proto store
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
+	"github.com/FAU-CDI/process_over_websocket/internal/rest_impl"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/store"
+)
+
+// memoryStore is a [store.Store] holding records in memory.
+type memoryStore struct {
+	m       sync.Mutex
+	records map[string]store.Record
+}
+
+func (ms *memoryStore) Save(record *store.Record) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+
+	if ms.records == nil {
+		ms.records = make(map[string]store.Record)
+	}
+	ms.records[record.ID] = *record
+	return nil
+}
+
+func (ms *memoryStore) Delete(id string) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+
+	delete(ms.records, id)
+	return nil
+}
+
+func (ms *memoryStore) List() ([]*store.Record, error) {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+
+	records := make([]*store.Record, 0, len(ms.records))
+	for _, record := range ms.records {
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+// get returns the record with the given id.
+func (ms *memoryStore) get(id string) (record store.Record, ok bool) {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+
+	record, ok = ms.records[id]
+	return record, ok
+}
+
+func TestSession_checkpoint(t *testing.T) {
+	t.Parallel()
+
+	st := &memoryStore{}
+	server := rest_impl.NewServer("/", testHandler, rest_impl.Options{
+		DisableSwaggerUI: true,
+		Store:            st,
+		Session:          rest_impl.SessionOpts{SaveInterval: 10 * time.Millisecond},
+	})
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+
+	// the output of the running process is persisted before it finishes
+	id, _ := startSession(t, httpServer, proto.CallMessage{Call: "block", Params: []string{"hello", "world"}})
+
+	want := []string{"hello", "world"}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		record, ok := st.get(id)
+		if ok && slices.Equal(record.Output.Lines, want) {
+			if record.Result != nil {
+				t.Errorf("got result %v, want pending", record.Result)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got output %v, want %v", record.Output.Lines, want)
+		}
+	}
+}
+
+func TestSession_Authorize(t *testing.T) {
+	t.Parallel()
+
+	alice := &proto.Principal{Name: "alice"}
+	bob := &proto.Principal{Name: "bob"}
+
+	// start returns a new session started by principal, if any
+	start := func(principal *proto.Principal) *rest_impl.Session {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodPost, "/new", nil)
+		if principal != nil {
+			r = r.WithContext(proto.WithPrincipal(r.Context(), principal))
+		}
+		ticket, err := (*limit.Limiter)(nil).Reserve(r, proto.CallMessage{Call: "block"})
+		if err != nil {
+			t.Fatalf("failed to reserve: %v", err)
+		}
+
+		var session rest_impl.Session
+		session.Init(t.Context(), rest_impl.SessionOpts{}, nil)
+		process, err := testHandler.Get(r, "block")
+		if err != nil {
+			t.Fatalf("failed to get process: %v", err)
+		}
+		if err := session.Start("id", r, process, proto.CallMessage{Call: "block"}, ticket); err != nil {
+			t.Fatalf("failed to start: %v", err)
+		}
+		return &session
+	}
+	owned := start(alice)
+	unowned := start(nil)
+
+	for _, tt := range []struct {
+		name       string
+		session    *rest_impl.Session
+		principal  *proto.Principal
+		secret     string
+		skipSecret bool
+		want       bool
+	}{
+		{"owner", owned, alice, "", false, true},
+		{"other principal", owned, bob, owned.Secret(), false, false},
+		{"anonymous", owned, nil, owned.Secret(), true, false},
+		{"secret", unowned, nil, unowned.Secret(), false, true},
+		{"secret of principal", unowned, bob, unowned.Secret(), false, true},
+		{"wrong secret", unowned, nil, "wrong", false, false},
+		{"no secret", unowned, nil, "", false, false},
+		{"secret disabled", unowned, nil, "", true, true},
+	} {
+		err := tt.session.Authorize(tt.principal, tt.secret, tt.skipSecret)
+		if got := err == nil; got != tt.want {
+			t.Errorf("%s: got error %v, want allowed %t", tt.name, err, tt.want)
+		}
+		if err != nil && !errors.Is(err, proto.ErrHandlerAuthorizationDenied) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, proto.ErrHandlerAuthorizationDenied)
+		}
+	}
+}
+
+func TestServer_retention(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	hash := sha256.Sum256([]byte("secret"))
+	st := &memoryStore{}
+	for _, record := range []store.Record{
+		{ID: "recent", Started: now.Add(-2 * time.Minute), Finished: now.Add(-time.Minute), Output: store.Lines{Lines: []string{"hello"}, Total: 1}, Result: &proto.Result{}},
+		{ID: "old", Started: now.Add(-3 * time.Hour), Finished: now.Add(-2 * time.Hour), Result: &proto.Result{}},
+		{ID: "interrupted", Started: now.Add(-2 * time.Hour)},
+	} {
+		record.SecretHash = hash[:]
+		_ = st.Save(&record)
+	}
+
+	server := rest_impl.NewServer("/", testHandler, rest_impl.Options{DisableSwaggerUI: true, Store: st, Retention: time.Hour})
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+
+	// sessions within the retention period are restored
+	status := getStatus(t, httpServer, "/status/recent", "secret")
+	if status.Result == nil || status.Result.Reason != nil || status.Buffer != "hello" {
+		t.Errorf("got status %+v, want fulfilled with output", status)
+	}
+	if _, ok := st.get("recent"); !ok {
+		t.Error("recent session was removed from the store")
+	}
+
+	// and older ones are removed from the store
+	for _, id := range []string{"old", "interrupted"} {
+		if _, ok := st.get(id); ok {
+			t.Errorf("%s: session was not removed from the store", id)
+		}
+	}
+}
+
+func TestSession_CloseInput(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/new", nil)
+	ticket, err := (*limit.Limiter)(nil).Reserve(r, proto.CallMessage{Call: "read"})
+	if err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+
+	// read returns all input read until the end of input
+	read := proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+		data, err := io.ReadAll(input)
+		return string(data), err
+	})
+
+	var session rest_impl.Session
+	session.Init(t.Context(), rest_impl.SessionOpts{}, nil)
+	if err := session.Start("id", r, read, proto.CallMessage{Call: "read"}, ticket); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	if _, err := session.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := session.CloseInput(); err != nil {
+		t.Fatalf("failed to close input: %v", err)
+	}
+
+	// further input is rejected
+	if _, err := session.Write([]byte("world")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("got error %v, want %v", err, io.ErrClosedPipe)
+	}
+
+	// but the process reads the input sent before, followed by the end of input
+	result, err := session.Wait(t.Context())
+	if err != nil || result != "hello" {
+		t.Errorf("got result %v and error %v, want %q", result, err, "hello")
+	}
+}
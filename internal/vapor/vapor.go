@@ -197,6 +197,29 @@ func (exp *Vapor[T]) GetNew(d time.Duration) (id string, elem *T, err error) {
 	return id, exp.initItem(entry), nil
 }
 
+var errIDExists = errors.New("GetNewWithID: ID already exists")
+
+// GetNewWithID is like GetNew, but creates the element with the given id instead of calling NewID.
+// If an element with the given id already exists, an error is returned.
+//
+// This can be used to restore elements that were previously created by a different vapor.
+func (vap *Vapor[T]) GetNewWithID(id string, d time.Duration) (*T, error) {
+	vap.start()
+
+	vap.stoppedM.Lock()
+	if vap.stopped {
+		vap.stoppedM.Unlock()
+		return nil, errStopped
+	}
+	item, found := vap.cache.GetOrSet(id, &entry[T]{}, ttlcache.WithTTL[string, *entry[T]](d), ttlcache.WithDisableTouchOnHit[string, *entry[T]]())
+	vap.stoppedM.Unlock()
+
+	if found {
+		return nil, errIDExists
+	}
+	return vap.initItem(item), nil
+}
+
 var errNotFound = errors.New("Get: ID not found (is it expired?)")
 
 // Get returns the element with the given id from the vapor.
@@ -135,6 +135,30 @@ func TestVapor_Close(t *testing.T) {
 	}
 }
 
+func TestVapor_GetNewWithID(t *testing.T) {
+	t.Parallel()
+
+	vap := vapor.Vapor[int]{
+		Initialize: func(i *int) { *i = 42 },
+		NewID:      func() string { return "generated" },
+	}
+	defer vap.Close()
+
+	// elements can be created with a specific id
+	elem, err := vap.GetNewWithID("restored", time.Hour)
+	if err != nil || *elem != 42 {
+		t.Fatalf("got element %v and error %v, want 42", elem, err)
+	}
+	if got, err := vap.Get("restored"); err != nil || got != elem {
+		t.Errorf("got element %v and error %v, want restored element", got, err)
+	}
+
+	// but only once
+	if _, err := vap.GetNewWithID("restored", time.Hour); err == nil {
+		t.Error("created element with existing id")
+	}
+}
+
 // CloseFunc has a Close Function.
 type CloseFunc func() error
 
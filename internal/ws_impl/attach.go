@@ -0,0 +1,170 @@
+//spellchecker:words impl
+package ws_impl
+
+//spellchecker:words context encoding json errors http time github process over websocket proto pkglib websocketx
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"go.tkw01536.de/pkglib/websocketx"
+)
+
+// SessionProvider provides existing sessions that clients can attach to using a [proto.AttachMessage].
+type SessionProvider interface {
+	// Session returns the session with the given id and keeps it from expiring.
+	// The client making r must be authorized to access it using the given secret.
+	Session(r *http.Request, id, secret string) (Session, error)
+}
+
+// Session is an existing session that clients can attach to.
+type Session interface {
+	// Write writes data to the input of the process.
+	// CloseInput closes it, so that the process reads any remaining input followed by [io.EOF].
+	Write(data []byte) (int, error)
+	CloseInput() error
+
+	// Status returns the status of the session.
+	// It only includes lines of output and error output with a sequence number greater than since and stderrSince respectively.
+	Status(since, stderrSince uint64) proto.Status
+
+	// Changed returns channels that are closed once the next line of output or error output is written respectively.
+	// Queued returns the position of the process in the queue, and a channel that is closed once it changes.
+	// Done returns a channel that is closed once the process has returned.
+	Changed() (output, stderr <-chan struct{})
+	Queued() (position int, changed <-chan struct{})
+	Done() <-chan struct{}
+
+	// CloseWith cancels the process with the given cause and waits for it to return.
+	CloseWith(cause error)
+}
+
+// attachKeepAlive is the interval in which attached sessions are kept from expiring.
+const attachKeepAlive = 15 * time.Second
+
+var errAttachUnsupported = errors.New("attaching to sessions not supported")
+
+// attach attaches the connection to an existing session.
+//
+// It forwards input from textMessages to the session, and output from the session to the connection.
+// Once the process has finished, returns its result.
+//
+// If ctx is cancelled because the client requested it, the session is cancelled.
+// If ctx is cancelled for any other reason, returns immediately and leaves the session running.
+func (server *Server) attach(ctx context.Context, conn connection, flow *flowControl, attach proto.AttachMessage, textMessages <-chan []byte) (any, error) {
+	if server.sessions == nil {
+		return nil, errAttachUnsupported
+	}
+
+	session, err := server.sessions.Session(conn.Request(), attach.Attach, attach.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach: %w", err)
+	}
+
+	// forward input to the session.
+	// This is deliberately not waited for, as writing blocks until the process reads the input or finishes.
+	go func() {
+		for text := range textMessages {
+			if text == nil {
+				_ = session.CloseInput()
+				continue
+			}
+			if _, err := session.Write(text); err == nil {
+				_ = flow.ack(conn)
+			}
+		}
+	}()
+
+	writeOutput := func(lines string) error {
+		if err := flow.take(ctx); err != nil {
+			return err
+		}
+		if err := conn.WriteText(lines); err != nil {
+			return fmt.Errorf("failed to write to connection: %w", err)
+		}
+		return nil
+	}
+	writeStderr := writeOutput
+	if attach.Stderr {
+		writeStderr = func(lines string) error {
+			if err := flow.take(ctx); err != nil {
+				return err
+			}
+			data, err := json.Marshal(proto.StderrMessage{Stderr: lines})
+			if err != nil {
+				return fmt.Errorf("failed to marshal error output: %w", err)
+			}
+			if err := conn.Write(websocketx.NewBinaryMessage(data)); err != nil {
+				return fmt.Errorf("failed to write to connection: %w", err)
+			}
+			return nil
+		}
+	}
+
+	keepalive := time.NewTicker(attachKeepAlive)
+	defer keepalive.Stop()
+
+	var (
+		since, stderrSince uint64
+		queued             int // position in the queue last sent
+		cancelled          = ctx.Done()
+	)
+	for {
+		// get the channels before the status, so that we don't miss any changes.
+		outChanged, errChanged := session.Changed()
+
+		position, queueChanged := session.Queued()
+		if attach.Queue && position != queued {
+			if err := writeQueued(conn, position); err != nil {
+				return nil, err
+			}
+			queued = position
+		}
+
+		status := session.Status(since, stderrSince)
+		if err := writeLines(writeOutput, status.Buffer, status.Cursor-since-status.Dropped); err != nil {
+			return nil, err
+		}
+		if err := writeLines(writeStderr, status.Stderr, status.StderrCursor-stderrSince-status.StderrDropped); err != nil {
+			return nil, err
+		}
+		since, stderrSince = status.Cursor, status.StderrCursor
+
+		// the process has finished
+		if status.Result != nil {
+			return status.Result.Value, status.Result.Reason
+		}
+
+		select {
+		case <-outChanged:
+		case <-errChanged:
+		case <-queueChanged:
+		case <-session.Done():
+		case <-keepalive.C:
+			if _, err := server.sessions.Session(conn.Request(), attach.Attach, attach.Secret); err != nil {
+				return nil, fmt.Errorf("session expired: %w", err)
+			}
+		case <-cancelled:
+			cause := context.Cause(ctx)
+			if !errors.Is(cause, proto.ErrCancelClientRequest) {
+				return nil, cause
+			}
+
+			// the client requested cancellation, so cancel the session and keep going until it returns.
+			cancelled = nil
+			go session.CloseWith(cause)
+		}
+	}
+}
+
+// writeLines writes the count lines held in buffer using write, unless count is zero.
+func writeLines(write func(lines string) error, buffer string, count uint64) error {
+	if count == 0 {
+		return nil
+	}
+	return write(buffer + "\n")
+}
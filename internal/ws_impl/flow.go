@@ -0,0 +1,95 @@
+//spellchecker:words impl
+package ws_impl
+
+//spellchecker:words context encoding json sync atomic github process over websocket proto pkglib websocketx
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"go.tkw01536.de/pkglib/websocketx"
+)
+
+// flowControl implements credit-based flow control for a single connection.
+// See [proto.CreditMessage] and [proto.AckMessage].
+//
+// The zero value is disabled, and can be enabled using enable.
+// While disabled, take and ack return immediately.
+type flowControl struct {
+	enabled atomic.Bool
+
+	// m protects the fields below
+	m       sync.Mutex
+	credits uint64        // number of output frames that may be sent
+	changed chan struct{} // closed and replaced when credits are granted
+
+	acked atomic.Uint64 // number of input frames consumed
+}
+
+// enable enables flow control, granting the given initial credits.
+// If credits is zero, flow control remains disabled.
+func (fc *flowControl) enable(credits uint64) {
+	if credits == 0 {
+		return
+	}
+	fc.grant(credits)
+	fc.enabled.Store(true)
+}
+
+// grant grants additional output credits.
+func (fc *flowControl) grant(credits uint64) {
+	fc.m.Lock()
+	defer fc.m.Unlock()
+
+	fc.credits += credits
+	if fc.changed != nil {
+		close(fc.changed)
+		fc.changed = nil
+	}
+}
+
+// take takes a single output credit, blocking until one is available or the context is closed.
+func (fc *flowControl) take(ctx context.Context) error {
+	if !fc.enabled.Load() {
+		return nil
+	}
+
+	for {
+		fc.m.Lock()
+		if fc.credits > 0 {
+			fc.credits--
+			fc.m.Unlock()
+			return nil
+		}
+		if fc.changed == nil {
+			fc.changed = make(chan struct{})
+		}
+		changed := fc.changed
+		fc.m.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to wait for credits: %w", context.Cause(ctx))
+		}
+	}
+}
+
+// ack acknowledges that an input frame was consumed by sending a [proto.AckMessage] to the client.
+func (fc *flowControl) ack(conn connection) error {
+	if !fc.enabled.Load() {
+		return nil
+	}
+
+	data, err := json.Marshal(proto.AckMessage{Ack: fc.acked.Add(1)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal ack: %w", err)
+	}
+	if err := conn.Write(websocketx.NewBinaryMessage(data)); err != nil {
+		return fmt.Errorf("failed to write to connection: %w", err)
+	}
+	return nil
+}
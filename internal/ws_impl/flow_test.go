@@ -0,0 +1,98 @@
+//spellchecker:words impl
+package ws_impl_test
+
+//spellchecker:words strconv testing time github process over websocket internal impl proto gorilla
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/ws_impl"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/gorilla/websocket"
+)
+
+// readFrames reads frames from conn in the background until the connection fails or the test ends.
+func readFrames(t *testing.T, conn *websocket.Conn) <-chan frame {
+	t.Helper()
+
+	frames := make(chan frame)
+	go func() {
+		defer close(frames)
+		for {
+			tp, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case frames <- frame{text: tp == websocket.TextMessage, payload: string(data)}:
+			case <-t.Context().Done():
+				return
+			}
+		}
+	}()
+	return frames
+}
+
+// nextFrame returns the next frame received, failing the test if none is received in time.
+func nextFrame(t *testing.T, frames <-chan frame) frame {
+	t.Helper()
+
+	select {
+	case f, ok := <-frames:
+		if !ok {
+			t.Fatal("connection closed")
+		}
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not receive a frame")
+	}
+	panic("never reached")
+}
+
+func TestServer_flowCredits(t *testing.T) {
+	t.Parallel()
+
+	conn := dial(t, newTestServer(t, ws_impl.Options{}), proto.Subprotocol, nil)
+	writeMessage(t, conn, proto.CallMessage{Call: "lines", Params: []string{"a", "b", "c"}, Credits: 1})
+	frames := readFrames(t, conn)
+
+	// only a single frame is sent
+	if got, want := nextFrame(t, frames), (frame{true, "a"}); got != want {
+		t.Errorf("got frame %v, want %v", got, want)
+	}
+	select {
+	case f := <-frames:
+		t.Fatalf("got frame %v without credits", f)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// until more credits are granted
+	writeMessage(t, conn, proto.CreditMessage{Credit: 2})
+	for _, want := range []frame{{true, "b"}, {true, "c"}, {false, `{"status":"fulfilled","value":null}`}} {
+		if got := nextFrame(t, frames); got != want {
+			t.Errorf("got frame %v, want %v", got, want)
+		}
+	}
+}
+
+func TestServer_flowAck(t *testing.T) {
+	t.Parallel()
+
+	conn := dial(t, newTestServer(t, ws_impl.Options{}), proto.Subprotocol, nil)
+	writeMessage(t, conn, proto.CallMessage{Call: "echo", Credits: 10})
+	frames := readFrames(t, conn)
+
+	// each input frame consumed is acknowledged
+	for i, input := range []string{"a", "b"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(input)); err != nil {
+			t.Fatalf("failed to write input: %v", err)
+		}
+
+		got := []frame{nextFrame(t, frames), nextFrame(t, frames)}
+		ack := frame{false, `{"ack":` + strconv.Itoa(i+1) + `}`}
+		if !(got[0] == ack && got[1] == frame{true, input}) && !(got[1] == ack && got[0] == frame{true, input}) {
+			t.Errorf("got frames %v, want %v and %q", got, ack, input)
+		}
+	}
+}
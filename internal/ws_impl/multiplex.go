@@ -0,0 +1,195 @@
+//spellchecker:words impl
+package ws_impl
+
+//spellchecker:words context encoding json http sync github process over websocket proto pkglib websocketx
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"go.tkw01536.de/pkglib/websocketx"
+)
+
+// multiplex serves a connection using [proto.SubprotocolMultiplex].
+//
+// Each channel is served like a separate connection using [proto.Subprotocol].
+// Frames that are not valid [proto.MultiplexMessage]s, or that are sent to a channel not in use, are ignored.
+func (server *Server) multiplex(conn *websocketx.Connection) {
+	var (
+		wg       sync.WaitGroup
+		m        sync.Mutex // protects channels
+		channels = make(map[uint64]*channel)
+	)
+	defer wg.Wait()
+
+	for {
+		select {
+		case msg := <-conn.Read():
+			// ignore anything that is not a valid message
+			if !msg.Binary() {
+				continue
+			}
+			var message proto.MultiplexMessage
+			if err := json.Unmarshal(msg.Body, &message); err != nil || (message.Text == nil) == (message.Message == nil) {
+				continue
+			}
+
+			// find the channel, or open a new one
+			m.Lock()
+			ch, ok := channels[message.Channel]
+			if !ok && message.Message != nil {
+				ch = newChannel(conn, message.Channel, func(ch *channel) {
+					m.Lock()
+					defer m.Unlock()
+
+					if channels[ch.id] == ch {
+						delete(channels, ch.id)
+					}
+				})
+				channels[message.Channel] = ch
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer ch.close()
+
+					_, _ = server.serve(ch)
+				}()
+			}
+			m.Unlock()
+
+			if ch == nil {
+				continue
+			}
+
+			if message.Text != nil {
+				ch.deliver(websocketx.NewTextMessage(*message.Text))
+			} else {
+				ch.deliver(websocketx.NewBinaryMessage(message.Message))
+			}
+
+		case <-conn.Context().Done():
+			return
+		}
+	}
+}
+
+// channel is a single channel of a connection using [proto.SubprotocolMultiplex].
+//
+// It implements [connection].
+//
+//nolint:containedctx
+type channel struct {
+	id   uint64
+	conn *websocketx.Connection
+
+	read   chan websocketx.Message
+	failed bool // set once the client exceeded the input window, only accessed by deliver
+
+	context context.Context
+	cancel  context.CancelFunc
+
+	closeOnce sync.Once
+	onClose   func(ch *channel)
+}
+
+// newChannel creates a new channel with the given id.
+// onClose is called once the channel is closed.
+func newChannel(conn *websocketx.Connection, id uint64, onClose func(ch *channel)) *channel {
+	ch := &channel{
+		id:      id,
+		conn:    conn,
+		read:    make(chan websocketx.Message, proto.InputWindow+messageBufferSize),
+		onClose: onClose,
+	}
+	ch.context, ch.cancel = context.WithCancel(conn.Context())
+	return ch
+}
+
+// deliver delivers a message received for this channel without blocking.
+//
+// Channels only buffer messages up to their input window, see [proto.MultiplexMessage].
+// If the client exceeds it, messages not yet read are dropped and replaced by an invalid message.
+// This causes the channel to be rejected with [proto.ErrCancelProtocolError].
+// Any further messages are dropped.
+func (ch *channel) deliver(msg websocketx.Message) {
+	if ch.failed {
+		return
+	}
+
+	select {
+	case ch.read <- msg:
+		return
+	case <-ch.context.Done():
+		return
+	default:
+	}
+
+	ch.failed = true
+	for len(ch.read) > 0 {
+		select {
+		case <-ch.read:
+		default:
+		}
+	}
+	ch.read <- websocketx.NewBinaryMessage(nil) // there is room, as deliver is the only sender
+}
+
+// close closes this channel, after which its id may be reused.
+func (ch *channel) close() {
+	ch.closeOnce.Do(func() {
+		ch.cancel()
+		ch.onClose(ch)
+	})
+}
+
+func (ch *channel) Context() context.Context {
+	return ch.context
+}
+
+func (ch *channel) Request() *http.Request {
+	return ch.conn.Request()
+}
+
+func (ch *channel) Read() <-chan websocketx.Message {
+	return ch.read
+}
+
+var errChannelClosed = errors.New("channel closed")
+
+func (ch *channel) Write(msg websocketx.Message) error {
+	if ch.context.Err() != nil {
+		return errChannelClosed
+	}
+
+	message := proto.MultiplexMessage{Channel: ch.id}
+	if msg.Text() {
+		text := string(msg.Body)
+		message.Text = &text
+	} else {
+		message.Message = msg.Body
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	if err := ch.conn.Write(websocketx.NewBinaryMessage(data)); err != nil {
+		return fmt.Errorf("failed to write to connection: %w", err)
+	}
+	return nil
+}
+
+func (ch *channel) WriteText(text string) error {
+	return ch.Write(websocketx.NewTextMessage(text))
+}
+
+// ShutdownWith closes the channel.
+// As channels have no close frames of their own, the frame is ignored.
+func (ch *channel) ShutdownWith(frame websocketx.CloseFrame) {
+	ch.close()
+}
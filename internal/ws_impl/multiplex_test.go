@@ -0,0 +1,127 @@
+//spellchecker:words impl
+package ws_impl_test
+
+//spellchecker:words encoding json testing time github process over websocket internal impl proto gorilla
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/ws_impl"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/gorilla/websocket"
+)
+
+// writeChannel writes a frame to the given channel of a multiplexed connection.
+// If text is non-nil, a text frame is written, otherwise message is written as a binary frame.
+func writeChannel(t *testing.T, conn *websocket.Conn, channel uint64, text *string, message any) {
+	t.Helper()
+
+	frame := proto.MultiplexMessage{Channel: channel, Text: text}
+	if text == nil {
+		data, err := json.Marshal(message)
+		if err != nil {
+			t.Fatalf("failed to marshal message: %v", err)
+		}
+		frame.Message = data
+	}
+	writeMessage(t, conn, frame)
+}
+
+// readChannels reads frames from a multiplexed connection until it received the results of the given number of channels.
+// It returns the text received and the result of each channel.
+func readChannels(t *testing.T, conn *websocket.Conn, count int) (texts map[uint64]string, results map[uint64]proto.Result) {
+	t.Helper()
+
+	texts = make(map[uint64]string)
+	results = make(map[uint64]proto.Result)
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(results) < count {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+
+		var frame proto.MultiplexMessage
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("failed to unmarshal frame: %v", err)
+		}
+		if frame.Text != nil {
+			texts[frame.Channel] += *frame.Text
+			continue
+		}
+
+		var result proto.Result
+		if err := json.Unmarshal(frame.Message, &result); err == nil {
+			results[frame.Channel] = result
+		}
+	}
+	return texts, results
+}
+
+func TestServer_multiplexStalled(t *testing.T) {
+	t.Parallel()
+
+	conn := dial(t, newTestServer(t, ws_impl.Options{}), proto.SubprotocolMultiplex, nil)
+
+	// the process on channel 1 never reads its input, but the client keeps sending it
+	writeChannel(t, conn, 1, nil, proto.CallMessage{Call: "block"})
+	input := "input"
+	for range 2 * proto.InputWindow {
+		writeChannel(t, conn, 1, &input, nil)
+	}
+
+	// which does not hold up channel 2
+	writeChannel(t, conn, 2, nil, proto.CallMessage{Call: "echo"})
+	hello := "hello"
+	writeChannel(t, conn, 2, &hello, nil)
+	writeChannel(t, conn, 2, nil, proto.SignalMessage{Signal: proto.SignalClose})
+
+	texts, results := readChannels(t, conn, 2)
+	if result := results[1]; result.Reason == nil {
+		t.Errorf("channel 1: got fulfilled result, want rejected")
+	} else if code, _ := proto.CodeOf(result.Reason); code != proto.CodeProtocolError {
+		t.Errorf("channel 1: got code %q, want %q", code, proto.CodeProtocolError)
+	}
+	if result := results[2]; result.Reason != nil {
+		t.Errorf("channel 2: got reason %v, want fulfilled", result.Reason)
+	}
+	if texts[2] != hello {
+		t.Errorf("channel 2: got output %q, want %q", texts[2], hello)
+	}
+}
+
+func TestServer_multiplex(t *testing.T) {
+	t.Parallel()
+
+	conn := dial(t, newTestServer(t, ws_impl.Options{}), proto.SubprotocolMultiplex, nil)
+
+	// input of interleaved channels is kept apart
+	writeChannel(t, conn, 1, nil, proto.CallMessage{Call: "echo"})
+	writeChannel(t, conn, 2, nil, proto.CallMessage{Call: "echo"})
+	for _, input := range []string{"a", "b", "c"} {
+		writeChannel(t, conn, 1, &input, nil)
+		other := input + input
+		writeChannel(t, conn, 2, &other, nil)
+	}
+	writeChannel(t, conn, 1, nil, proto.SignalMessage{Signal: proto.SignalClose})
+	writeChannel(t, conn, 2, nil, proto.SignalMessage{Signal: proto.SignalClose})
+
+	texts, results := readChannels(t, conn, 2)
+	for channel, want := range map[uint64]string{1: "abc", 2: "aabbcc"} {
+		if texts[channel] != want {
+			t.Errorf("channel %d: got output %q, want %q", channel, texts[channel], want)
+		}
+		if results[channel].Reason != nil {
+			t.Errorf("channel %d: got reason %v, want fulfilled", channel, results[channel].Reason)
+		}
+	}
+
+	// and channels can be reused once they are done
+	writeChannel(t, conn, 1, nil, proto.CallMessage{Call: "lines", Params: []string{"again"}})
+	texts, results = readChannels(t, conn, 1)
+	if texts[1] != "again" || results[1].Reason != nil {
+		t.Errorf("got output %q and reason %v, want %q and fulfilled", texts[1], results[1].Reason, "again")
+	}
+}
@@ -0,0 +1,417 @@
+//spellchecker:words impl
+package ws_impl
+
+//spellchecker:words context encoding json errors sync time github process over websocket internal coalesce limit proto pkglib recovery websocketx
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/coalesce"
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"go.tkw01536.de/pkglib/recovery"
+	"go.tkw01536.de/pkglib/websocketx"
+)
+
+// resumeMaxFrames is the maximal number of frames buffered by a resumable session.
+const resumeMaxFrames = 10_000
+
+// resumableSession is a process that keeps running when the connection it was started by is lost.
+//
+// Output is buffered as frames, each of which is assigned a sequence number.
+//
+//nolint:containedctx
+type resumableSession struct {
+	// context and cancel can be used to cancel the underlying process
+	context context.Context
+	cancel  context.CancelCauseFunc
+
+	// input to the process.
+	// Only forward writes to inw, receiving input from the connection currently attached via input.
+	inr   *io.PipeReader
+	inw   *io.PipeWriter
+	input chan input
+
+	// done is closed once the process has returned.
+	// Afterwards result is set.
+	done   chan struct{}
+	result proto.Result
+
+	// m protects the fields below
+	m sync.Mutex
+
+	frames  []frame       // ring of at most resumeMaxFrames frames
+	start   int           // index of the oldest frame in frames
+	total   uint64        // total number of frames, i.e. the sequence number of the newest frame
+	changed chan struct{} // closed and replaced whenever a new frame is added
+
+	detach    context.CancelCauseFunc // cancels the connection currently attached, if any
+	forwarder <-chan struct{}         // closed once the connection currently attached stops forwarding input, if any
+	ticket    *limit.Ticket           // slot to run the process in, or its place in the queue
+	owner     *proto.Principal        // principal that started the session, if any
+}
+
+// input is a message of input sent by a connection.
+type input struct {
+	text []byte // nil closes the input
+	ack  func() // called once text has been written
+}
+
+// frame is a single frame of output.
+type frame struct {
+	stderr bool // send as a [proto.StderrMessage] instead of a text frame
+	data   string
+}
+
+// init initializes this session.
+func (rs *resumableSession) init(ctx context.Context) {
+	rs.context, rs.cancel = context.WithCancelCause(ctx)
+	rs.inr, rs.inw = io.Pipe()
+	rs.input = make(chan input)
+	rs.done = make(chan struct{})
+	rs.changed = make(chan struct{})
+}
+
+// closeWith cancels the session with the given error, and waits for the process to return.
+func (rs *resumableSession) closeWith(err error) {
+	rs.cancel(err)
+	_ = rs.inw.Close()
+	_ = rs.inr.Close()
+
+	<-rs.done
+}
+
+// run runs the given process in this session, once ticket holds a slot.
+// If principal is non-nil, it is made available to the process.
+func (rs *resumableSession) run(principal *proto.Principal, process proto.Process, call proto.CallMessage, ticket *limit.Ticket, options coalesce.Options) {
+	defer close(rs.done)
+	defer rs.cancel(proto.ErrCancelHandlerReturn)
+	defer func() { _ = rs.inw.Close() }()
+
+	go rs.forward()
+
+	coalescer := coalesce.New(options)
+	defer func() { _ = coalescer.Close() }()
+
+	output := coalescer.Writer(rs.writer(false))
+	stderr := output
+	if call.Stderr {
+		stderr = coalescer.Writer(rs.writer(true))
+	}
+
+	value, err := func() (value any, err error) {
+		defer func() {
+			if e := recovery.Recover(recover()); e != nil {
+				err = e
+			}
+		}()
+
+		defer ticket.Release()
+		if err := ticket.Wait(rs.context, nil); err != nil {
+			return nil, err
+		}
+
+		ctx := rs.context
+		if principal != nil {
+			ctx = proto.WithPrincipal(ctx, principal)
+		}
+		return proto.Do(ctx, process, rs.inr, output, stderr, call.Params...)
+	}()
+	if err != nil {
+		err = fmt.Errorf("process returned error: %w", err)
+	}
+	rs.result = proto.Result{Value: value, Reason: err}
+}
+
+// forward writes input sent by attached connections to the process until it has returned.
+// As it is the only writer, input of different connections is never interleaved.
+func (rs *resumableSession) forward() {
+	for {
+		select {
+		case <-rs.done:
+			return
+		case in := <-rs.input:
+			if in.text == nil {
+				_ = rs.inw.Close()
+				continue
+			}
+			if _, err := rs.inw.Write(in.text); err == nil {
+				in.ack()
+			}
+		}
+	}
+}
+
+// writer returns a writer that adds a frame for each write.
+func (rs *resumableSession) writer(stderr bool) io.Writer {
+	return WriterFunc(func(b []byte) (int, error) {
+		rs.m.Lock()
+		defer rs.m.Unlock()
+
+		rs.total++
+
+		// notify anyone waiting for changes
+		close(rs.changed)
+		rs.changed = make(chan struct{})
+
+		f := frame{stderr: stderr, data: string(b)}
+		if len(rs.frames) < resumeMaxFrames {
+			rs.frames = append(rs.frames, f)
+		} else {
+			rs.frames[rs.start] = f
+			rs.start = (rs.start + 1) % len(rs.frames)
+		}
+		return len(b), nil
+	})
+}
+
+var errResumeUnavailable = errors.New("frames requested for resuming are no longer available")
+
+// since returns the frames with a sequence number greater than since, and the sequence number of the newest frame.
+// It also returns a channel that is closed once a new frame is added.
+//
+// If frames after since are no longer available, returns an error.
+func (rs *resumableSession) since(since uint64) (frames []frame, next uint64, changed <-chan struct{}, err error) {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	oldest := rs.total - uint64(len(rs.frames)) // sequence number before the oldest available frame
+	if since < oldest || since > rs.total {
+		return nil, 0, nil, errResumeUnavailable
+	}
+
+	frames = make([]frame, 0, rs.total-since)
+	for i := int(since - oldest); i < len(rs.frames); i++ { //nolint:gosec // at most len(rs.frames)
+		frames = append(frames, rs.frames[(rs.start+i)%len(rs.frames)])
+	}
+	return frames, rs.total, rs.changed, nil
+}
+
+// queued returns the position of the process in the queue, or 0 if it is not waiting to start.
+// It also returns a channel that is closed once the position changes.
+func (rs *resumableSession) queued() (position int, changed <-chan struct{}) {
+	rs.m.Lock()
+	ticket := rs.ticket
+	rs.m.Unlock()
+
+	if ticket == nil {
+		return 0, nil
+	}
+	return ticket.State()
+}
+
+// attach marks the connection with the given cancel function as attached, detaching any previous connection.
+// forwarder should be closed once the connection stops forwarding input.
+//
+// It returns the channel passed by the previous connection, if any.
+// The connection should only forward input once it has been closed.
+func (rs *resumableSession) attach(cancel context.CancelCauseFunc, forwarder <-chan struct{}) (previous <-chan struct{}) {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	if rs.detach != nil {
+		rs.detach(errResumedElsewhere)
+	}
+	rs.detach = cancel
+
+	previous, rs.forwarder = rs.forwarder, forwarder
+	return previous
+}
+
+var errResumeForbidden = fmt.Errorf("%w: session belongs to a different client", proto.ErrHandlerAuthorizationDenied)
+
+// authorize checks that the given principal may resume this session.
+//
+// If the session was started by a principal, only the same principal may resume it.
+// Otherwise, knowing the token is sufficient.
+// If access is denied, returns an error wrapping [proto.ErrHandlerAuthorizationDenied].
+func (rs *resumableSession) authorize(principal *proto.Principal) error {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	if rs.owner != nil && (principal == nil || principal.Name != rs.owner.Name) {
+		return errResumeForbidden
+	}
+	return nil
+}
+
+var (
+	errResumeNotFound   = errors.New("session to resume not found")
+	errResumedElsewhere = errors.New("session resumed by another connection")
+)
+
+// startResumable starts the given process in a new resumable session, and relays it to the connection.
+// The process is run once ticket holds a slot, and release is called once it has returned.
+func (server *Server) startResumable(ctx context.Context, cancel context.CancelCauseFunc, conn connection, flow *flowControl, process proto.Process, call proto.CallMessage, ticket *limit.Ticket, release func(), textMessages <-chan []byte) (any, error) {
+	token, session, err := server.resumable.GetNew(server.resumeGracePeriod)
+	if err != nil {
+		ticket.Release()
+		release()
+		return nil, fmt.Errorf("failed to create resumable session: %w", err)
+	}
+
+	principal := proto.PrincipalFrom(ctx)
+
+	session.m.Lock()
+	session.ticket = ticket
+	session.owner = principal
+	session.m.Unlock()
+
+	go func() {
+		defer release()
+		session.run(principal, process, call, ticket, server.coalesce)
+	}()
+
+	// tell the client how to resume
+	data, err := json.Marshal(proto.TokenMessage{Token: token})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal token: %w", err)
+	}
+	if err := conn.Write(websocketx.NewBinaryMessage(data)); err != nil {
+		return nil, fmt.Errorf("failed to write to connection: %w", err)
+	}
+
+	return server.relay(ctx, cancel, conn, flow, call.Queue, token, session, 0, textMessages)
+}
+
+// resume resumes a previously started resumable session.
+// The client must be authorized to resume it, see [resumableSession.authorize].
+func (server *Server) resume(ctx context.Context, cancel context.CancelCauseFunc, conn connection, flow *flowControl, resume proto.ResumeMessage, textMessages <-chan []byte) (any, error) {
+	session, err := server.resumable.Get(resume.Resume)
+	if err != nil {
+		return nil, errResumeNotFound
+	}
+	if err := session.authorize(proto.PrincipalFrom(ctx)); err != nil {
+		return nil, err
+	}
+	return server.relay(ctx, cancel, conn, flow, resume.Queue, resume.Resume, session, resume.Since, textMessages)
+}
+
+// relay attaches the connection to the given resumable session.
+//
+// It forwards input from textMessages to the session, and frames after since to the connection.
+// If queue is true, it also tells the client about the position of the process in the queue.
+// Once the process has finished and all frames have been sent, returns its result.
+//
+// If ctx is cancelled because the client requested it, the session is cancelled.
+// If ctx is cancelled for any other reason, returns immediately and leaves the session running for the grace period.
+func (server *Server) relay(ctx context.Context, cancel context.CancelCauseFunc, conn connection, flow *flowControl, queue bool, token string, session *resumableSession, since uint64, textMessages <-chan []byte) (any, error) {
+	forwarder := make(chan struct{})
+	previous := session.attach(cancel, forwarder)
+
+	// forward input to the session, once the previous connection (which attach detached) has stopped doing so.
+	// This is deliberately not waited for, as handing over input blocks until the process reads the previous input.
+	go func() {
+		defer close(forwarder)
+		if previous != nil {
+			<-previous
+		}
+
+		ack := func() { _ = flow.ack(conn) }
+		for {
+			var text []byte
+			select {
+			case <-ctx.Done():
+				return
+			case t, ok := <-textMessages:
+				if !ok {
+					return
+				}
+				text = t
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case session.input <- input{text: text, ack: ack}:
+			}
+		}
+	}()
+
+	// keep the session from expiring while we are connected
+	keepalive := time.NewTicker(max(server.resumeGracePeriod/2, time.Millisecond))
+	defer keepalive.Stop()
+
+	var (
+		queued    int // position in the queue last sent
+		cancelled = ctx.Done()
+	)
+	for {
+		// check if we are done before getting the frames, so that we don't miss any.
+		var done bool
+		select {
+		case <-session.done:
+			done = true
+		default:
+		}
+
+		position, queueChanged := session.queued()
+		if queue && position != queued {
+			if err := writeQueued(conn, position); err != nil {
+				return nil, err
+			}
+			queued = position
+		}
+
+		frames, next, changed, err := session.since(since)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range frames {
+			if err := flow.take(ctx); err != nil {
+				return nil, err
+			}
+			if err := writeFrame(conn, f); err != nil {
+				return nil, err
+			}
+		}
+		since = next
+
+		if done {
+			return session.result.Value, session.result.Reason
+		}
+
+		select {
+		case <-changed:
+		case <-queueChanged:
+		case <-session.done:
+		case <-keepalive.C:
+			if _, err := server.resumable.Get(token); err != nil {
+				return nil, errResumeNotFound
+			}
+		case <-cancelled:
+			cause := context.Cause(ctx)
+			if !errors.Is(cause, proto.ErrCancelClientRequest) {
+				return nil, cause
+			}
+
+			// the client requested cancellation, so cancel the session and keep going until it returns.
+			cancelled = nil
+			session.cancel(cause)
+		}
+	}
+}
+
+// writeFrame writes a frame to the connection.
+func writeFrame(conn connection, f frame) error {
+	if !f.stderr {
+		if err := conn.WriteText(f.data); err != nil {
+			return fmt.Errorf("failed to write to connection: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(proto.StderrMessage{Stderr: f.data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal error output: %w", err)
+	}
+	if err := conn.Write(websocketx.NewBinaryMessage(data)); err != nil {
+		return fmt.Errorf("failed to write to connection: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+//spellchecker:words impl
+package ws_impl_test
+
+//spellchecker:words encoding json http slices testing time github process over websocket auth internal impl proto gorilla
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/auth"
+	"github.com/FAU-CDI/process_over_websocket/internal/ws_impl"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/gorilla/websocket"
+)
+
+func TestServer_resumeOwner(t *testing.T) {
+	t.Parallel()
+
+	url := newTestServer(t, ws_impl.Options{
+		ResumeGracePeriod: time.Minute,
+		Authenticator:     &auth.Bearer{Verify: auth.Tokens(map[string]string{"alice": "alice", "bob": "bob"})},
+	})
+	alice := http.Header{"Authorization": []string{"Bearer alice"}}
+	bob := http.Header{"Authorization": []string{"Bearer bob"}}
+
+	// alice starts a resumable session and loses the connection
+	conn := dial(t, url, proto.Subprotocol, alice)
+	writeMessage(t, conn, proto.CallMessage{Call: "echo", Resumable: true})
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+	var token proto.TokenMessage
+	if err := json.Unmarshal(data, &token); err != nil || token.Token == "" {
+		t.Fatalf("got message %s, want token", data)
+	}
+	_ = conn.Close()
+
+	// bob can not resume it, even with the token
+	conn = dial(t, url, proto.Subprotocol, bob)
+	writeMessage(t, conn, proto.ResumeMessage{Resume: token.Token})
+	if _, result := readResult(t, conn); result.Reason == nil {
+		t.Error("bob: got fulfilled result, want rejected")
+	} else if code, _ := proto.CodeOf(result.Reason); code != proto.CodeAuthorizationDenied {
+		t.Errorf("bob: got code %q, want %q", code, proto.CodeAuthorizationDenied)
+	}
+
+	// but alice can
+	conn = dial(t, url, proto.Subprotocol, alice)
+	writeMessage(t, conn, proto.ResumeMessage{Resume: token.Token})
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	writeMessage(t, conn, proto.SignalMessage{Signal: proto.SignalClose})
+
+	frames, result := readResult(t, conn)
+	if result.Reason != nil {
+		t.Errorf("alice: got reason %v, want fulfilled", result.Reason)
+	}
+	if want := []frame{{true, "hello"}}; len(frames) != 1 || frames[0] != want[0] {
+		t.Errorf("alice: got frames %v, want %v", frames, want)
+	}
+}
+
+func TestServer_resumeSince(t *testing.T) {
+	t.Parallel()
+
+	url := newTestServer(t, ws_impl.Options{ResumeGracePeriod: time.Minute})
+
+	// start a resumable session and receive two frames of output
+	conn := dial(t, url, proto.Subprotocol, nil)
+	writeMessage(t, conn, proto.CallMessage{Call: "echo", Resumable: true})
+	frames := readFrames(t, conn)
+
+	var token proto.TokenMessage
+	if err := json.Unmarshal([]byte(nextFrame(t, frames).payload), &token); err != nil || token.Token == "" {
+		t.Fatalf("failed to read token: %v", err)
+	}
+	for _, input := range []string{"a", "b"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(input)); err != nil {
+			t.Fatalf("failed to write input: %v", err)
+		}
+		if got, want := nextFrame(t, frames), (frame{true, input}); got != want {
+			t.Fatalf("got frame %v, want %v", got, want)
+		}
+	}
+	_ = conn.Close()
+
+	// frames after the last one received are sent again, and input is taken from the new connection
+	conn = dial(t, url, proto.Subprotocol, nil)
+	writeMessage(t, conn, proto.ResumeMessage{Resume: token.Token, Since: 1})
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("c")); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	writeMessage(t, conn, proto.SignalMessage{Signal: proto.SignalClose})
+
+	got, result := readResult(t, conn)
+	if result.Reason != nil {
+		t.Errorf("got reason %v, want fulfilled", result.Reason)
+	}
+	if want := []frame{{true, "b"}, {true, "c"}}; !slices.Equal(got, want) {
+		t.Errorf("got frames %v, want %v", got, want)
+	}
+
+	// but unknown sessions can not be resumed
+	conn = dial(t, url, proto.Subprotocol, nil)
+	writeMessage(t, conn, proto.ResumeMessage{Resume: "unknown"})
+	if _, result := readResult(t, conn); result.Reason == nil {
+		t.Error("got fulfilled result for unknown session, want rejected")
+	}
+}
@@ -1,7 +1,7 @@
 //spellchecker:words impl
 package ws_impl
 
-//spellchecker:words context encoding json http strings sync time github process over websocket internal clean proto pkglib errorsx websocketx
+//spellchecker:words context encoding json http strings sync time github process over websocket internal clean coalesce limit origin quota reject vapor proto google uuid gorilla pkglib errorsx websocketx
 import (
 	"context"
 	"encoding/json"
@@ -14,7 +14,15 @@ import (
 	"time"
 
 	"github.com/FAU-CDI/process_over_websocket/internal/clean"
+	"github.com/FAU-CDI/process_over_websocket/internal/coalesce"
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
+	"github.com/FAU-CDI/process_over_websocket/internal/origin"
+	"github.com/FAU-CDI/process_over_websocket/internal/quota"
+	"github.com/FAU-CDI/process_over_websocket/internal/reject"
+	"github.com/FAU-CDI/process_over_websocket/internal/vapor"
 	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"go.tkw01536.de/pkglib/errorsx"
 	"go.tkw01536.de/pkglib/websocketx"
 )
@@ -25,24 +33,77 @@ var (
 	readCallTimeout = time.Second // timeout for reading the call parameters
 )
 
-type Options = websocketx.Options
+// Options are options for the websocket server.
+type Options struct {
+	websocketx.Options
+
+	// ResumeGracePeriod is the time a resumable session keeps running after its connection is lost.
+	// If it is not positive, clients can not start resumable sessions.
+	ResumeGracePeriod time.Duration
+
+	// Coalesce configures batching of output written by processes into fewer frames.
+	Coalesce coalesce.Options
+
+	// Authenticator, if non-nil, authenticates websocket requests before upgrading them.
+	Authenticator proto.Authenticator
+
+	// Origins configures which origins may open websocket connections, see [origin.Options].
+	// By default, only connections from the same origin are allowed.
+	Origins origin.Options
+
+	// Limiter, if non-nil, limits the number of processes running at once.
+	// Processes waiting for a slot report their position in the queue using [proto.QueuedMessage]s, if the client requested them.
+	Limiter *limit.Limiter
+
+	// Quota, if non-nil, limits the processes each client may start.
+	// Clients exceeding it are rejected with the code [proto.CodeRateLimited].
+	Quota *quota.Quota
+}
 
 // NewServer creates a new server to handle websocket connections.
+//
+// Requests that are not websocket requests are passed to fallback.
+// If fallback implements [SessionProvider], clients may attach to its sessions.
 func NewServer(path string, handler proto.Handler, fallback http.Handler, options Options) *Server {
 	server := &Server{
 		path: clean.Clean(path),
 		server: websocketx.Server{
-			Options:  options,
+			Options:  options.Options,
 			Fallback: fallback,
 		},
-		handler: handler,
+		handler:           handler,
+		resumeGracePeriod: options.ResumeGracePeriod,
+		coalesce:          options.Coalesce,
+		authenticator:     options.Authenticator,
+		origins:           options.Origins,
+		limiter:           options.Limiter,
+		quota:             options.Quota,
+	}
+	server.sessions, _ = fallback.(SessionProvider)
+
+	// setup resumable sessions
+	server.context, server.cancel = context.WithCancelCause(context.Background())
+	server.resumable.NewID = func() string {
+		uuid, err := uuid.NewRandom()
+		if err != nil {
+			return ""
+		}
+		return uuid.String()
+	}
+	server.resumable.Initialize = func(rs *resumableSession) {
+		rs.init(server.context)
+	}
+	server.resumable.Finalize = func(fr vapor.FinalizeReason, rs *resumableSession) {
+		if fr == vapor.FinalizeReasonExpired {
+			go rs.closeWith(proto.ErrCancelClientGone)
+		}
 	}
 
 	// setup the handler for the server
 	server.server.Handler = server.handle
 
 	// set up the subprotocols
-	server.server.Options.Subprotocols = []string{proto.Subprotocol}
+	server.server.Options.Subprotocols = []string{proto.SubprotocolMultiplex, proto.Subprotocol}
 	server.server.RequireProtocols()
 
 	// return the server
@@ -59,13 +120,41 @@ func NewServer(path string, handler proto.Handler, fallback http.Handler, option
 // To call an action, a client should send a [proto.CallMessage] struct.
 // The server will then start handling input and output (via text messages).
 // If the client sends a [proto.SignalMessage], the signal is propagated to the underlying context.
+// If the client requested it in the call, error output is sent using [proto.StderrMessage]s.
+// If the client enabled flow control in the call, output is limited by credits granted using [proto.CreditMessage]s, and input is acknowledged using [proto.AckMessage]s.
+//
+// Instead of a [proto.CallMessage], the client may send a [proto.AttachMessage] to attach to an existing session, see [SessionProvider].
 //
 // If nothing unexpected happens (e.g. an abnormal closure from the client), the server will close the connection and send a
 // [proto.ResultMessage] to the client.
+//
+// If too many processes are running, the process waits for a slot and, if requested in the call, the server sends [proto.QueuedMessage]s meanwhile.
+//
+// If the client requests a resumable session in the call, and the server has a positive grace period, the server first sends a [proto.TokenMessage].
+// If the connection is lost, the process keeps running for the grace period, and the client may resume it by sending a [proto.ResumeMessage] instead of a call.
+//
+// Clients using [proto.SubprotocolMultiplex] instead of [proto.Subprotocol] may run several processes over the same connection.
+// Each frame is then wrapped in a [proto.MultiplexMessage].
+//
+//nolint:containedctx
 type Server struct {
-	path    string
-	server  websocketx.Server
-	handler proto.Handler
+	path     string
+	server   websocketx.Server
+	handler  proto.Handler
+	sessions SessionProvider // may be nil
+
+	// context for resumable sessions, which outlive their connections
+	context context.Context
+	cancel  context.CancelCauseFunc
+
+	resumeGracePeriod time.Duration
+	resumable         vapor.Vapor[resumableSession]
+
+	coalesce      coalesce.Options
+	authenticator proto.Authenticator // may be nil
+	origins       origin.Options
+	limiter       *limit.Limiter // may be nil
+	quota         *quota.Quota   // may be nil
 }
 
 // ServeHTTP implements handling the protocol.
@@ -74,6 +163,26 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+
+	// check and authenticate websocket requests, other requests are handled by the fallback
+	if websocket.IsWebSocketUpgrade(r) {
+		if !server.origins.Allowed(r) {
+			origin.Forbid(w)
+			return
+		}
+
+		r = reject.Authenticate(w, r, server.authenticator)
+		if r == nil {
+			return
+		}
+
+		// the origin has been checked above, don't let the upgrader apply its own check
+		if r.Header.Get("Origin") != "" {
+			r = r.Clone(r.Context())
+			r.Header.Del("Origin")
+		}
+	}
+
 	server.server.ServeHTTP(w, r)
 }
 
@@ -85,17 +194,32 @@ func (server *Server) isValidRequestPath(path string) bool {
 }
 
 func (server *Server) handle(conn *websocketx.Connection) {
-	_, _ = server.serve(conn)
+	switch conn.Subprotocol() {
+	case proto.Subprotocol:
+		_, _ = server.serve(conn)
+	case proto.SubprotocolMultiplex:
+		server.multiplex(conn)
+	default:
+		panic("server did not enforce subprotocol")
+	}
 }
 
-var errUnknown = errors.New("unknown error")
+// connection is a connection serving a single process using [proto.Subprotocol].
+// It is implemented by [*websocketx.Connection], and by [*channel] for connections using [proto.SubprotocolMultiplex].
+type connection interface {
+	Context() context.Context
+	Request() *http.Request
 
-func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
-	// check that the client specified the correct subprotocol.
-	if conn.Subprotocol() != proto.Subprotocol {
-		panic("server did not enforce subprotocol")
-	}
+	Read() <-chan websocketx.Message
+	Write(msg websocketx.Message) error
+	WriteText(text string) error
+
+	ShutdownWith(frame websocketx.CloseFrame)
+}
+
+var errUnknown = errors.New("unknown error")
 
+func (server *Server) serve(conn connection) (res any, err error) {
 	var wg sync.WaitGroup
 
 	// once we have finished executing send a binary message (indicating success) to the client.
@@ -129,14 +253,26 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 	// create a channel for all future text messages
 	// which will receive a nil to close
 	var (
-		textMessages   = make(chan []byte, messageBufferSize) // input text from the client
-		initialMessage = make(chan []byte, 1)                 // initial binary message (only ever received once)
+		textMessages   = make(chan []byte, max(messageBufferSize, proto.InputWindow+1)) // input text from the client
+		initialMessage = make(chan []byte, 1)                                           // initial binary message (only ever received once)
+
+		flow flowControl // flow control, if enabled by the client
 	)
 
+	// channels of multiplexed connections must never block the connection they share with other channels.
+	// So their input is limited to the input window even without flow control.
+	_, multiplexed := conn.(*channel)
+	windowed := func() bool { return multiplexed || flow.enabled.Load() }
+
 	// create a context to be canceled once done
 	ctx, cancel := context.WithCancelCause(conn.Context())
 	defer cancel(proto.ErrCancelHandlerReturn)
 
+	// make the principal available to the process
+	if principal := proto.PrincipalFrom(conn.Request().Context()); principal != nil {
+		ctx = proto.WithPrincipal(ctx, principal)
+	}
+
 	// start processing messages
 	wg.Add(1)
 	go func() {
@@ -150,6 +286,20 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 			hadCancelBefore   = false // did we receive the cancel signal previously?
 		)
 
+		// closeInput sends a flag message (nil) to close the text messages channel
+		closeInput := func() {
+			if !windowed() {
+				textMessages <- nil
+				return
+			}
+
+			// the input window leaves room for a flag message, so if the channel is full, one is already queued.
+			select {
+			case textMessages <- nil:
+			default:
+			}
+		}
+
 		for {
 			select {
 			case msg := <-conn.Read():
@@ -159,6 +309,17 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 					if msg.Body == nil {
 						msg.Body = []byte{}
 					}
+
+					// the client may not exceed the input window, if any.
+					if windowed() {
+						if len(textMessages) >= proto.InputWindow {
+							cancel(proto.ErrCancelProtocolError)
+							continue
+						}
+						textMessages <- msg.Body
+						continue
+					}
+
 					textMessages <- msg.Body
 					continue
 				}
@@ -177,19 +338,25 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 					continue
 				}
 
-				// attempt to decode signal message
+				// attempt to decode signal or credit message
 				// and if we fail, cancel with a protocol error
-				var signal proto.SignalMessage
+				var signal struct {
+					proto.SignalMessage
+					proto.CreditMessage
+				}
 				if err := json.Unmarshal(msg.Body, &signal); err != nil {
 					cancel(proto.ErrCancelProtocolError)
 					continue
 				}
 
 				switch {
+				case signal.Signal == "" && signal.Credit > 0:
+					// client granted more output credits
+					flow.grant(signal.Credit)
+
 				case signal.Signal == proto.SignalClose:
 					// client has requested to close the text messages channel
-					// so send a flag message (nil) to do the closing
-					textMessages <- nil
+					closeInput()
 
 				case signal.Signal == proto.SignalCancel && !hadCancelBefore:
 					// client canceled for the first time
@@ -198,7 +365,7 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 				case signal.Signal == proto.SignalCancel && hadCancelBefore:
 					// client canceled for the second time
 					// so we also close the input channel
-					textMessages <- nil
+					closeInput()
 					hadCancelBefore = true
 
 				default:
@@ -214,14 +381,23 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 		}
 	}()
 
-	// read the call message
-	var call proto.CallMessage
+	// read the call, query, attach or resume message
+	var message struct {
+		proto.CallMessage
+		proto.QueryMessage
+
+		// fields of [proto.AttachMessage] and [proto.ResumeMessage] not also contained in [proto.CallMessage].
+		Attach string `json:"attach"`
+		Secret string `json:"secret"`
+		Resume string `json:"resume"`
+		Since  uint64 `json:"since"`
+	}
 	select {
 	case buffer := <-initialMessage:
 
 		// try to read the protocol message.
 		// and if we fail to unmarshal it, fail with a protocol error
-		if err := json.Unmarshal(buffer, &call); err != nil {
+		if err := json.Unmarshal(buffer, &message); err != nil {
 			return nil, proto.ErrCancelProtocolError
 		}
 
@@ -229,12 +405,52 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 		return nil, proto.ErrCancelTimeout
 	}
 
+	// the client only wanted to query some information
+	if message.Query != "" {
+		return server.query(conn.Request(), message.Query)
+	}
+
+	// the client wants flow control
+	flow.enable(message.Credits)
+
+	// the client wants to attach to an existing session
+	if message.Attach != "" {
+		return server.attach(ctx, conn, &flow, proto.AttachMessage{Attach: message.Attach, Secret: message.Secret, Stderr: message.Stderr, Credits: message.Credits, Queue: message.Queue}, textMessages)
+	}
+
+	// the client wants to resume a resumable session
+	if message.Resume != "" {
+		return server.resume(ctx, cancel, conn, &flow, proto.ResumeMessage{Resume: message.Resume, Since: message.Since, Credits: message.Credits, Queue: message.Queue}, textMessages)
+	}
+
+	call := message.CallMessage
+
 	// Find the right process
 	process, err := server.handler.Get(conn.Request(), call.Call, call.Params...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get process: %w", err)
 	}
 
+	// check the quota of the client
+	release, err := server.quota.Acquire(conn.Request())
+	if err != nil {
+		return nil, fmt.Errorf("failed to start process: %w", err)
+	}
+
+	// reserve a slot, or a place in the queue
+	ticket, err := server.limiter.Reserve(conn.Request(), call)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to start process: %w", err)
+	}
+
+	// the client wants the process to outlive the connection
+	if call.Resumable && server.resumeGracePeriod > 0 {
+		return server.startResumable(ctx, cancel, conn, &flow, process, call, ticket, release, textMessages)
+	}
+	defer release()
+	defer ticket.Release()
+
 	// create a pipe to handle the input
 	reader, writer := io.Pipe()
 	defer errorsx.Close(writer, &err, "writer")
@@ -249,7 +465,9 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 			if text == nil {
 				goto no_more
 			}
-			_, _ = writer.Write(text)
+			if _, err := writer.Write(text); err == nil {
+				_ = flow.ack(conn)
+			}
 		}
 
 	no_more:
@@ -260,29 +478,109 @@ func (server *Server) serve(conn *websocketx.Connection) (res any, err error) {
 		}
 	}()
 
-	// write the output to the client as it comes in!
-	// TODO: We may eventually need buffering here ...
-	output := WriterFunc(func(b []byte) (int, error) {
+	// write the output to the client as it comes in, batching small writes if configured.
+	// With flow control, this blocks until the client has granted credits.
+	coalescer := coalesce.New(server.coalesce)
+	output := coalescer.Writer(WriterFunc(func(b []byte) (int, error) {
+		if err := flow.take(ctx); err != nil {
+			return 0, err
+		}
 		if err := conn.WriteText(string(b)); err != nil {
 			return 0, fmt.Errorf("failed to write to connection: %w", err)
 		}
 		return len(b), nil
-	})
+	}))
+
+	// write error output along with the output, unless requested otherwise.
+	stderr := output
+	if call.Stderr {
+		stderr = coalescer.Writer(WriterFunc(func(b []byte) (int, error) {
+			if err := flow.take(ctx); err != nil {
+				return 0, err
+			}
+			data, err := json.Marshal(proto.StderrMessage{Stderr: string(b)})
+			if err != nil {
+				return 0, fmt.Errorf("failed to marshal error output: %w", err)
+			}
+			if err := conn.Write(websocketx.NewBinaryMessage(data)); err != nil {
+				return 0, fmt.Errorf("failed to write to connection: %w", err)
+			}
+			return len(b), nil
+		}))
+	}
+
+	// send any output still held back once the process is done, even if it failed.
+	// This runs before the result is written, so that no output is sent after it.
+	defer func() {
+		if cerr := coalescer.Close(); cerr != nil && err == nil {
+			res, err = nil, fmt.Errorf("failed to write output: %w", cerr)
+		}
+	}()
+
+	// wait for a slot, telling the client about its position in the queue if requested
+	var onQueued func(position int)
+	if call.Queue {
+		onQueued = func(position int) { _ = writeQueued(conn, position) }
+	}
+	if err := ticket.Wait(ctx, onQueued); err != nil {
+		return nil, err
+	}
 
 	// do the actual processing
-	value, err := process.Do(ctx, reader, output, call.Params...)
+	value, err := proto.Do(ctx, process, reader, output, stderr, call.Params...)
 	if err != nil {
 		return nil, fmt.Errorf("process returned error: %w", err)
 	}
 	return value, nil
 }
 
+// writeQueued tells the client about the position of its process in the queue.
+func writeQueued(conn connection, position int) error {
+	data, err := json.Marshal(proto.QueuedMessage{Queued: position})
+	if err != nil {
+		return fmt.Errorf("failed to marshal queue position: %w", err)
+	}
+	if err := conn.Write(websocketx.NewBinaryMessage(data)); err != nil {
+		return fmt.Errorf("failed to write to connection: %w", err)
+	}
+	return nil
+}
+
+// query answers the given query.
+func (server *Server) query(r *http.Request, query proto.Query) (any, error) {
+	switch query {
+	case proto.QueryProcesses:
+		lister, ok := server.handler.(proto.ProcessLister)
+		if !ok {
+			return nil, proto.ErrQueryUnsupported
+		}
+
+		processes := lister.Processes(r)
+		if processes == nil {
+			processes = []proto.ProcessInfo{}
+		}
+		return processes, nil
+	}
+	return nil, fmt.Errorf("%w: %q", proto.ErrQueryUnsupported, query)
+}
+
+var errServerClose = errors.New("server closing")
+
 func (server *Server) Close() {
 	server.server.Close()
+	server.closeResumable()
 }
 
 func (server *Server) Shutdown() {
 	server.server.Shutdown()
+	server.closeResumable()
+}
+
+// closeResumable cancels all resumable sessions and waits for them to finish.
+func (server *Server) closeResumable() {
+	server.cancel(errServerClose)
+	server.resumable.EvictAfter(func(rs *resumableSession) { rs.closeWith(errServerClose) })
+	server.resumable.Close()
 }
 
 // WriterFunc implements io.Writer using a function.
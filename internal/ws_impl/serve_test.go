@@ -0,0 +1,207 @@
+//spellchecker:words impl
+package ws_impl_test
+
+//spellchecker:words context encoding json errors http httptest strings testing time github process over websocket internal coalesce limit impl proto gorilla
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/coalesce"
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
+	"github.com/FAU-CDI/process_over_websocket/internal/ws_impl"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/gorilla/websocket"
+)
+
+var errFailed = errors.New("failed")
+
+// testHandler provides processes used by the tests.
+var testHandler = proto.HandlerFunc(func(r *http.Request, name string, args ...string) (proto.Process, error) {
+	switch name {
+	case "echo":
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			if _, err := io.Copy(output, input); err != nil {
+				return nil, fmt.Errorf("failed to copy: %w", err)
+			}
+			return nil, nil
+		}), nil
+	case "lines":
+		// writes each argument separately
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			for _, arg := range args {
+				if _, err := io.WriteString(output, arg); err != nil {
+					return nil, fmt.Errorf("failed to write: %w", err)
+				}
+			}
+			return nil, nil
+		}), nil
+	case "partial":
+		// writes a partial line to the error output and fails
+		return proto.StderrProcessFunc(func(ctx context.Context, input io.Reader, output, stderr io.Writer, args ...string) (any, error) {
+			_, _ = io.WriteString(output, "line\n")
+			_, _ = io.WriteString(stderr, "fatal: something broke")
+			return nil, errFailed
+		}), nil
+	case "block":
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			<-ctx.Done()
+			return nil, context.Cause(ctx)
+		}), nil
+	}
+	return nil, proto.ErrHandlerUnknownProcess
+})
+
+// newTestServer starts a new websocket server for testing and returns its url.
+func newTestServer(t *testing.T, options ws_impl.Options) string {
+	t.Helper()
+
+	server := ws_impl.NewServer("/", testHandler, http.NotFoundHandler(), options)
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+	return "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+// dial opens a connection to the server at url using the given subprotocol and request header.
+func dial(t *testing.T, url string, subprotocol string, header http.Header) *websocket.Conn {
+	t.Helper()
+
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	conn, res, err := dialer.DialContext(t.Context(), url, header)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	_ = res.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// writeMessage writes message as a binary frame to conn.
+func writeMessage(t *testing.T, conn *websocket.Conn, message any) {
+	t.Helper()
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("failed to marshal message: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+}
+
+// frame is a frame received from the server.
+type frame struct {
+	text    bool
+	payload string
+}
+
+// readResult reads frames from conn until it receives the result.
+// It returns the frames received before it.
+func readResult(t *testing.T, conn *websocket.Conn) (frames []frame, result proto.Result) {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		tp, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		if tp == websocket.BinaryMessage {
+			if err := json.Unmarshal(data, &result); err == nil {
+				return frames, result
+			}
+		}
+		frames = append(frames, frame{text: tp == websocket.TextMessage, payload: string(data)})
+	}
+}
+
+func TestServer_coalesceError(t *testing.T) {
+	t.Parallel()
+
+	// hold back output far longer than the test runs
+	url := newTestServer(t, ws_impl.Options{Coalesce: coalesce.Options{Window: time.Hour}})
+
+	for _, tt := range []struct {
+		name   string
+		stderr bool
+		want   []frame
+	}{
+		{"output", false, []frame{{true, "line\n"}, {true, "fatal: something broke"}}},
+		{"stderr", true, []frame{{true, "line\n"}, {false, `{"stderr":"fatal: something broke"}`}}},
+	} {
+		conn := dial(t, url, proto.Subprotocol, nil)
+		writeMessage(t, conn, proto.CallMessage{Call: "partial", Stderr: tt.stderr})
+
+		// the partial line is sent before the result
+		frames, result := readResult(t, conn)
+		if fmt.Sprint(frames) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got frames %v, want %v", tt.name, frames, tt.want)
+		}
+		if result.Reason == nil || !strings.Contains(result.Reason.Error(), errFailed.Error()) {
+			t.Errorf("%s: got reason %v, want to contain %q", tt.name, result.Reason, errFailed)
+		}
+	}
+}
+
+func TestServer_queued(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name  string
+		queue bool
+		want  []frame
+	}{
+		{"not requested", false, []frame{{true, "line\n"}, {true, "fatal: something broke"}}},
+		{"requested", true, []frame{{false, `{"queued":1}`}, {false, `{"queued":0}`}, {true, "line\n"}, {true, "fatal: something broke"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// reserved receives a value once the second process is about to be queued
+			reserved := make(chan struct{}, 1)
+			url := newTestServer(t, ws_impl.Options{
+				Limiter: limit.New(limit.Options{Max: 1, QueueSize: 1, MaxPriority: func(r *http.Request, name string) int {
+					if name == "partial" {
+						reserved <- struct{}{}
+					}
+					return 0
+				}}),
+			})
+
+			// start a process and wait until it runs
+			running := dial(t, url, proto.Subprotocol, nil)
+			writeMessage(t, running, proto.CallMessage{Call: "echo"})
+			if err := running.WriteMessage(websocket.TextMessage, []byte("a")); err != nil {
+				t.Fatalf("failed to write input: %v", err)
+			}
+			_ = running.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, _, err := running.ReadMessage(); err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+
+			// queue another one behind it
+			queued := dial(t, url, proto.Subprotocol, nil)
+			writeMessage(t, queued, proto.CallMessage{Call: "partial", Queue: tt.queue})
+			<-reserved
+
+			// and let it run
+			writeMessage(t, running, proto.SignalMessage{Signal: proto.SignalClose})
+			readResult(t, running)
+
+			frames, _ := readResult(t, queued)
+			if fmt.Sprint(frames) != fmt.Sprint(tt.want) {
+				t.Errorf("got frames %v, want %v", frames, tt.want)
+			}
+		})
+	}
+}
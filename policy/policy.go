@@ -0,0 +1,337 @@
+// Package policy implements access control for [proto.Handler]s.
+//
+//spellchecker:words policy
+package policy
+
+//spellchecker:words bytes encoding json errors http path regexp slices sync github process over websocket proto
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Effect is the effect of a [Rule].
+type Effect string
+
+// Effects of rules.
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Policy decides which principals may call which processes with which arguments.
+//
+// Rules are checked in order, and the first rule matching a call decides if it is allowed.
+// If no rule matches, the Default effect applies.
+//
+// A Policy must not be modified once it has been used.
+// It is safe for concurrent use.
+type Policy struct {
+	// Default is the effect if no rule matches a call.
+	// Defaults to [Deny].
+	Default Effect `json:"default,omitempty" yaml:"default,omitempty"`
+
+	// Members assigns additional roles to principals by name.
+	// These are used in addition to the roles of the principal itself, see [proto.Principal.Roles].
+	Members map[string][]string `json:"members,omitempty" yaml:"members,omitempty"`
+
+	// Rules are the rules of this policy.
+	Rules []Rule `json:"rules" yaml:"rules"`
+
+	once sync.Once
+	err  error
+}
+
+// Rule is a single rule of a [Policy].
+type Rule struct {
+	// Effect is the effect of this rule if it matches a call.
+	Effect Effect `json:"effect" yaml:"effect"`
+
+	// Processes holds patterns for the names of processes this rule applies to, see [path.Match].
+	// For example, "backup/*" matches all processes in the "backup" namespace.
+	// If empty, the rule applies to all processes.
+	Processes []string `json:"processes,omitempty" yaml:"processes,omitempty"`
+
+	// Roles and Principals hold the roles and names of principals this rule applies to.
+	// The rule applies if the principal has any of the roles or any of the names.
+	// If both are empty, the rule applies to everyone, including unauthenticated clients.
+	Roles      []string `json:"roles,omitempty"      yaml:"roles,omitempty"`
+	Principals []string `json:"principals,omitempty" yaml:"principals,omitempty"`
+
+	// Args holds regular expressions the arguments of a call must match for this rule to apply.
+	// Each argument must entirely match the expression at the same position,
+	// arguments beyond the last expression must match the last expression.
+	// If empty, the rule applies regardless of arguments.
+	Args []string `json:"args,omitempty" yaml:"args,omitempty"`
+
+	// MaxPriority is the highest priority principals matched by an allow rule may request, see [Policy.MaxPriority].
+	MaxPriority int `json:"maxPriority,omitempty" yaml:"maxPriority,omitempty"`
+
+	args []*regexp.Regexp
+}
+
+var (
+	errInvalidEffect = errors.New("invalid effect")
+	errNoDefault     = errors.New("no rule matches")
+)
+
+// Compile checks that the policy is valid and prepares it for use.
+// It is called automatically when the policy is first used, and only needs to be called to find errors early.
+func (policy *Policy) Compile() error {
+	policy.once.Do(func() {
+		policy.err = policy.compile()
+	})
+	return policy.err
+}
+
+func (policy *Policy) compile() error {
+	if err := policy.Default.validate(true); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for i := range policy.Rules {
+		if err := policy.Rules[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (effect Effect) validate(allowEmpty bool) error {
+	if effect == Allow || effect == Deny || (allowEmpty && effect == "") {
+		return nil
+	}
+	return fmt.Errorf("%w %q", errInvalidEffect, effect)
+}
+
+func (rule *Rule) compile() error {
+	if err := rule.Effect.validate(false); err != nil {
+		return err
+	}
+	for _, pattern := range rule.Processes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid process pattern %q: %w", pattern, err)
+		}
+	}
+
+	rule.args = make([]*regexp.Regexp, len(rule.Args))
+	for i, expr := range rule.Args {
+		re, err := regexp.Compile(`^(?:` + expr + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid argument pattern %q: %w", expr, err)
+		}
+		rule.args[i] = re
+	}
+	return nil
+}
+
+// Check checks if principal may call the process with the given name and arguments.
+// The principal may be nil for unauthenticated clients.
+//
+// If the call is not allowed, returns an error wrapping [proto.ErrHandlerAuthorizationDenied].
+func (policy *Policy) Check(principal *proto.Principal, name string, args ...string) error {
+	if err := policy.Compile(); err != nil {
+		return fmt.Errorf("%w: invalid policy: %w", proto.ErrHandlerAuthorizationDenied, err)
+	}
+
+	roles := policy.roles(principal)
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if !rule.matchesProcess(name) || !rule.matchesPrincipal(principal, roles) || !rule.matchesArgs(args) {
+			continue
+		}
+		if rule.Effect == Allow {
+			return nil
+		}
+		return fmt.Errorf("%w: denied by rule %d", proto.ErrHandlerAuthorizationDenied, i)
+	}
+
+	if policy.Default == Allow {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", proto.ErrHandlerAuthorizationDenied, errNoDefault)
+}
+
+// Permits reports if principal may call the process with the given name with at least some arguments.
+func (policy *Policy) Permits(principal *proto.Principal, name string) bool {
+	if policy.Compile() != nil {
+		return false
+	}
+
+	roles := policy.roles(principal)
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if !rule.matchesProcess(name) || !rule.matchesPrincipal(principal, roles) {
+			continue
+		}
+		if rule.Effect == Allow {
+			return true
+		}
+		if len(rule.args) == 0 {
+			return false
+		}
+		// a deny rule restricted by arguments may not apply to other arguments
+	}
+	return policy.Default == Allow
+}
+
+// MaxPriority returns the highest priority principal may request for the process with the given name, see [proto.CallMessage].
+// It is the MaxPriority of the first allow rule applying to principal and the process regardless of arguments, or 0 if there is none.
+//
+// It can be used for the corresponding option of a server.
+func (policy *Policy) MaxPriority(principal *proto.Principal, name string) int {
+	if policy.Compile() != nil {
+		return 0
+	}
+
+	roles := policy.roles(principal)
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Effect == Allow && rule.matchesProcess(name) && rule.matchesPrincipal(principal, roles) {
+			return rule.MaxPriority
+		}
+	}
+	return 0
+}
+
+// roles returns the roles of the given principal.
+func (policy *Policy) roles(principal *proto.Principal) []string {
+	if principal == nil {
+		return nil
+	}
+	return append(slices.Clone(principal.Roles), policy.Members[principal.Name]...)
+}
+
+func (rule *Rule) matchesProcess(name string) bool {
+	if len(rule.Processes) == 0 {
+		return true
+	}
+	for _, pattern := range rule.Processes {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *Rule) matchesPrincipal(principal *proto.Principal, roles []string) bool {
+	if len(rule.Roles) == 0 && len(rule.Principals) == 0 {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	if slices.Contains(rule.Principals, principal.Name) {
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(rule.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *Rule) matchesArgs(args []string) bool {
+	if len(rule.args) == 0 {
+		return true
+	}
+	for i, arg := range args {
+		re := rule.args[min(i, len(rule.args)-1)]
+		if !re.MatchString(arg) {
+			return false
+		}
+	}
+	return true
+}
+
+// Unmarshal decodes data into v.
+// Examples are [json.Unmarshal] and the Unmarshal function of yaml libraries.
+type Unmarshal func(data []byte, v any) error
+
+// Parse parses and compiles a policy from data using the given unmarshal function.
+// If unmarshal is nil, data is decoded as json, rejecting unknown fields.
+func Parse(data []byte, unmarshal Unmarshal) (*Policy, error) {
+	if unmarshal == nil {
+		unmarshal = unmarshalJSON
+	}
+
+	var policy Policy
+	if err := unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %w", err)
+	}
+	if err := policy.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return &policy, nil
+}
+
+// ReadFile reads a policy from the named file, see [Parse].
+//
+// To read yaml files, pass the Unmarshal function of a yaml library, such as gopkg.in/yaml.v3.
+func ReadFile(filename string, unmarshal Unmarshal) (*Policy, error) {
+	data, err := os.ReadFile(filename) // #nosec G304 -- filename is explicitly provided by the caller
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	return Parse(data, unmarshal)
+}
+
+func unmarshalJSON(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("failed to decode json: %w", err)
+	}
+	return nil
+}
+
+// New returns a handler that only permits calls to handler allowed by policy.
+// Calls that are not allowed are rejected with an error wrapping [proto.ErrHandlerAuthorizationDenied].
+//
+// The principal making a call is determined using [proto.PrincipalFrom].
+// If handler implements [proto.ProcessLister], so does the returned handler, listing only processes permitted by policy.
+func New(handler proto.Handler, policy *Policy) (proto.Handler, error) {
+	if err := policy.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	h := &policyHandler{handler: handler, policy: policy}
+	if lister, ok := handler.(proto.ProcessLister); ok {
+		return &listingHandler{policyHandler: h, lister: lister}, nil
+	}
+	return h, nil
+}
+
+type policyHandler struct {
+	handler proto.Handler
+	policy  *Policy
+}
+
+func (h *policyHandler) Get(r *http.Request, name string, args ...string) (proto.Process, error) {
+	if err := h.policy.Check(proto.PrincipalFrom(r.Context()), name, args...); err != nil {
+		return nil, err
+	}
+	//nolint:wrapcheck // wrapped handler should decide on its own errors
+	return h.handler.Get(r, name, args...)
+}
+
+type listingHandler struct {
+	*policyHandler
+	lister proto.ProcessLister
+}
+
+func (h *listingHandler) Processes(r *http.Request) []proto.ProcessInfo {
+	principal := proto.PrincipalFrom(r.Context())
+	return slices.DeleteFunc(h.lister.Processes(r), func(info proto.ProcessInfo) bool {
+		return !h.policy.Permits(principal, info.Name)
+	})
+}
@@ -0,0 +1,196 @@
+//spellchecker:words policy
+package policy_test
+
+//spellchecker:words context errors http httptest path filepath testing github process over websocket policy proto registry
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket/policy"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/registry"
+)
+
+const testPolicy = `{
+	"members": {"alice": ["admin"]},
+	"rules": [
+		{"effect": "allow", "processes": ["backup/*"], "roles": ["admin"]},
+		{"effect": "deny", "processes": ["echo"], "args": [".*", "secret.*"]},
+		{"effect": "allow", "processes": ["status", "echo"]},
+		{"effect": "allow", "processes": ["restore"], "principals": ["bob"], "args": ["[a-z]+"]}
+	]
+}`
+
+func testHandler(t *testing.T) proto.Handler {
+	t.Helper()
+
+	p, err := policy.Parse([]byte(testPolicy), nil)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	noop := func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+		return nil, nil
+	}
+
+	var reg registry.Registry
+	for _, name := range []string{"status", "echo", "restore", "backup/create", "backup/delete"} {
+		reg.RegisterFunc(name, registry.Info{Variadic: true}, noop)
+	}
+
+	handler, err := policy.New(&reg, p)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	return handler
+}
+
+func TestNew_Get(t *testing.T) {
+	t.Parallel()
+
+	handler := testHandler(t)
+
+	alice := &proto.Principal{Name: "alice"}
+	bob := &proto.Principal{Name: "bob"}
+	carol := &proto.Principal{Name: "carol", Roles: []string{"admin"}}
+
+	tests := []struct {
+		principal *proto.Principal
+		name      string
+		args      []string
+		wantErr   error
+	}{
+		{nil, "status", nil, nil},
+		{bob, "status", nil, nil},
+		{nil, "backup/create", nil, proto.ErrHandlerAuthorizationDenied},
+		{bob, "backup/create", nil, proto.ErrHandlerAuthorizationDenied},
+		{alice, "backup/create", nil, nil},
+		{carol, "backup/delete", nil, nil},
+		{bob, "echo", []string{"hello", "world"}, nil},
+		{bob, "echo", []string{"hello", "secret"}, proto.ErrHandlerAuthorizationDenied},
+		{bob, "restore", []string{"abc"}, nil},
+		{bob, "restore", []string{"abc", "../etc"}, proto.ErrHandlerAuthorizationDenied},
+		{alice, "restore", []string{"abc"}, proto.ErrHandlerAuthorizationDenied},
+		{alice, "unknown", nil, proto.ErrHandlerAuthorizationDenied},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.principal != nil {
+			r = r.WithContext(proto.WithPrincipal(r.Context(), tt.principal))
+		}
+
+		_, err := handler.Get(r, tt.name, tt.args...)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%v: Get(%q, %v) got error %v, want %v", tt.principal, tt.name, tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNew_Processes(t *testing.T) {
+	t.Parallel()
+
+	lister, ok := testHandler(t).(proto.ProcessLister)
+	if !ok {
+		t.Fatal("handler does not implement ProcessLister")
+	}
+
+	for _, tt := range []struct {
+		principal *proto.Principal
+		want      []string
+	}{
+		{nil, []string{"echo", "status"}},
+		{&proto.Principal{Name: "alice"}, []string{"backup/create", "backup/delete", "echo", "status"}},
+		{&proto.Principal{Name: "bob"}, []string{"echo", "restore", "status"}},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.principal != nil {
+			r = r.WithContext(proto.WithPrincipal(r.Context(), tt.principal))
+		}
+
+		var got []string
+		for _, info := range lister.Processes(r) {
+			got = append(got, info.Name)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got processes %v, want %v", tt.principal, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v: got processes %v, want %v", tt.principal, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPolicy_MaxPriority(t *testing.T) {
+	t.Parallel()
+
+	p, err := policy.Parse([]byte(`{
+		"members": {"alice": ["admin"]},
+		"rules": [
+			{"effect": "allow", "processes": ["*"], "roles": ["admin"], "maxPriority": 10},
+			{"effect": "allow", "processes": ["*"], "maxPriority": 1}
+		]
+	}`), nil)
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	for _, tt := range []struct {
+		principal *proto.Principal
+		name      string
+		want      int
+	}{
+		{&proto.Principal{Name: "alice"}, "backup", 10},
+		{&proto.Principal{Name: "bob"}, "status", 1},
+		{nil, "status", 1},
+	} {
+		if got := p.MaxPriority(tt.principal, tt.name); got != tt.want {
+			t.Errorf("MaxPriority(%v, %q) got %d, want %d", tt.principal, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{
+		`{"rules": [{"effect": "maybe"}]}`,
+		`{"default": "sometimes"}`,
+		`{"rules": [{"effect": "allow", "processes": ["["]}]}`,
+		`{"rules": [{"effect": "allow", "args": ["("]}]}`,
+		`{"rules": [{"effect": "allow", "role": ["admin"]}]}`,
+	} {
+		if _, err := policy.Parse([]byte(data), nil); err == nil {
+			t.Errorf("Parse(%s) got no error", data)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(`{"default": "allow", "rules": [{"effect": "deny", "processes": ["backup"]}]}`), 0o600); err != nil {
+		t.Fatalf("failed to write policy: %v", err)
+	}
+
+	p, err := policy.ReadFile(path, nil)
+	if err != nil {
+		t.Fatalf("failed to read policy: %v", err)
+	}
+	if err := p.Check(nil, "status"); err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+	if err := p.Check(nil, "backup"); !errors.Is(err, proto.ErrHandlerAuthorizationDenied) {
+		t.Errorf("got error %v, want %v", err, proto.ErrHandlerAuthorizationDenied)
+	}
+}
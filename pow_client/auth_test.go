@@ -0,0 +1,153 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words encoding json errors http testing github process over websocket auth client proto
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/auth"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// authOptions configure a server requiring the bearer token "secret" (for alice) or "other" (for bob).
+var authOptions = process_over_websocket.Options{
+	Authenticator: &auth.Bearer{Verify: auth.Tokens(map[string]string{"secret": "alice", "other": "bob"})},
+}
+
+func TestAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	rest, ws := newRemotes(t, testHandler, authOptions)
+	header := http.Header{"Authorization": []string{"Bearer secret"}}
+
+	// unauthenticated requests are rejected
+	_, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "whoami"})
+	var rerr *pow_client.ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusUnauthorized || !errors.Is(err, proto.ErrUnauthenticated) {
+		t.Errorf("got error %v, want status %d", err, http.StatusUnauthorized)
+	}
+	if _, err := pow_client.Dial(t.Context(), ws, proto.CallMessage{Call: "whoami"}); err == nil {
+		t.Error("unauthenticated websocket connection succeeded")
+	}
+
+	// authenticated requests make the principal available to the process
+	rest.Header = header
+	session, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "whoami"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	status, err := session.Wait(t.Context(), testWaitOptions)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	checkWhoami(t, status.Result, "alice")
+
+	ws.Header = header
+	wsSession, err := pow_client.Dial(t.Context(), ws, proto.CallMessage{Call: "whoami"})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	result, err := wsSession.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	checkWhoami(t, result, "alice")
+}
+
+func TestRestSession_owner(t *testing.T) {
+	t.Parallel()
+
+	rest, _ := newRemotes(t, testHandler, authOptions)
+
+	rest.Header = http.Header{"Authorization": []string{"Bearer secret"}}
+	session, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	// another principal can not access the session, even with the secret
+	res := getStatus(t, rest.URL+"status/"+session.ID()+"?secret="+session.Secret(), http.Header{"Authorization": []string{"Bearer other"}})
+	if res != http.StatusForbidden {
+		t.Errorf("got status %d for other principal, want %d", res, http.StatusForbidden)
+	}
+
+	// the owner can
+	if err := session.Cancel(t.Context()); err != nil {
+		t.Errorf("failed to cancel: %v", err)
+	}
+}
+
+func TestRestSession_secret(t *testing.T) {
+	t.Parallel()
+
+	rest, _ := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if session.Secret() == "" {
+		t.Fatal("did not receive a secret")
+	}
+
+	for _, tt := range []struct {
+		name   string
+		url    string
+		header http.Header
+		want   int
+	}{
+		{"no secret", rest.URL + "status/" + session.ID(), nil, http.StatusForbidden},
+		{"wrong secret", rest.URL + "status/" + session.ID(), http.Header{proto.SessionSecretHeader: []string{"wrong"}}, http.StatusForbidden},
+		{"header", rest.URL + "status/" + session.ID(), http.Header{proto.SessionSecretHeader: []string{session.Secret()}}, http.StatusOK},
+		{"query", rest.URL + "status/" + session.ID() + "?secret=" + session.Secret(), nil, http.StatusOK},
+	} {
+		if got := getStatus(t, tt.url, tt.header); got != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// the session itself passes the secret along
+	if err := session.Cancel(t.Context()); err != nil {
+		t.Errorf("failed to cancel: %v", err)
+	}
+}
+
+// getStatus makes a GET request to url and returns the status code of the response.
+func getStatus(t *testing.T, url string, header http.Header) int {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header = header
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to make request: %v", err)
+	}
+	_ = res.Body.Close()
+	return res.StatusCode
+}
+
+// checkWhoami checks that result holds the given principal name.
+func checkWhoami(t *testing.T, result *proto.Result, want string) {
+	t.Helper()
+
+	if result == nil || result.Reason != nil {
+		t.Fatalf("got result %v, want fulfilled", result)
+	}
+
+	var name string
+	if err := json.Unmarshal(result.Value.(json.RawMessage), &name); err != nil {
+		t.Fatalf("failed to decode value: %v", err)
+	}
+	if name != want {
+		t.Errorf("got principal %q, want %q", name, want)
+	}
+}
@@ -0,0 +1,119 @@
+//spellchecker:words client
+package pow_client
+
+//spellchecker:words bytes sync
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// outputBuffer is an unbounded in-memory pipe.
+// Writes never block, reads block until data is available or the buffer is closed.
+//
+// Each write is treated as a frame.
+// Once frames have been read completely, onConsume is called with the number of frames.
+type outputBuffer struct {
+	m    sync.Mutex
+	cond *sync.Cond
+
+	buf     bytes.Buffer
+	pending []int // number of unread bytes of each frame in buf
+	err     error // non-nil once closed
+
+	onConsume func(frames uint64)
+}
+
+func newOutputBuffer(onConsume func(frames uint64)) *outputBuffer {
+	ob := &outputBuffer{onConsume: onConsume}
+	ob.cond = sync.NewCond(&ob.m)
+	return ob
+}
+
+func (ob *outputBuffer) Write(data []byte) (int, error) {
+	n, consumed, err := ob.write(data)
+	if consumed > 0 {
+		ob.onConsume(consumed)
+	}
+	return n, err
+}
+
+func (ob *outputBuffer) write(data []byte) (n int, consumed uint64, err error) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+
+	if ob.err != nil {
+		return 0, 0, io.ErrClosedPipe
+	}
+
+	// empty frames can never be read, so they are consumed immediately
+	if len(data) == 0 {
+		return 0, 1, nil
+	}
+
+	defer ob.cond.Broadcast()
+
+	n, err = ob.buf.Write(data)
+	ob.pending = append(ob.pending, n)
+	if err != nil {
+		return n, 0, fmt.Errorf("failed to write to buffer: %w", err)
+	}
+	return n, 0, nil
+}
+
+// CloseWithError closes the buffer.
+// Once all buffered data has been read, Read returns err, or [io.EOF] if err is nil.
+func (ob *outputBuffer) CloseWithError(err error) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+
+	if ob.err != nil {
+		return
+	}
+
+	if err == nil {
+		err = io.EOF
+	}
+	ob.err = err
+	ob.cond.Broadcast()
+}
+
+func (ob *outputBuffer) Read(data []byte) (int, error) {
+	n, consumed, err := ob.read(data)
+	if consumed > 0 {
+		ob.onConsume(consumed)
+	}
+	return n, err
+}
+
+func (ob *outputBuffer) read(data []byte) (n int, consumed uint64, err error) {
+	ob.m.Lock()
+	defer ob.m.Unlock()
+
+	for ob.buf.Len() == 0 && ob.err == nil {
+		ob.cond.Wait()
+	}
+
+	if ob.buf.Len() == 0 {
+		return 0, 0, ob.err
+	}
+
+	n, err = ob.buf.Read(data)
+
+	// count the frames that have been read completely
+	rest := n
+	for len(ob.pending) > 0 && rest >= ob.pending[0] {
+		rest -= ob.pending[0]
+		ob.pending = ob.pending[1:]
+		consumed++
+	}
+	if len(ob.pending) > 0 {
+		ob.pending[0] -= rest
+	}
+
+	if err != nil {
+		return n, consumed, fmt.Errorf("failed to read from buffer: %w", err)
+	}
+	return n, consumed, nil
+}
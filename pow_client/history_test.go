@@ -0,0 +1,142 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words errors http testing time github process over websocket internal history rest impl client proto
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/internal/history"
+	"github.com/FAU-CDI/process_over_websocket/internal/rest_impl"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// waitSessions waits until the history of the server at remote lists count sessions matching query.
+func waitSessions(t *testing.T, remote pow_client.Remote, query pow_client.SessionQuery, count int) pow_client.SessionList {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		list, err := pow_client.ListSessions(t.Context(), remote, query)
+		if err != nil {
+			t.Fatalf("failed to list sessions: %v", err)
+		}
+		if len(list.Sessions) == count {
+			return list
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d sessions, want %d", len(list.Sessions), count)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+
+	rest, _ := newRemotes(t, testHandler, process_over_websocket.Options{
+		RESTOptions: rest_impl.Options{DisableSessionSecret: true, History: history.Options{MaxEntries: 10}},
+	})
+
+	// run one process to completion, and cancel another one
+	fulfilled, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "stderr"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if _, err := fulfilled.Wait(t.Context(), testWaitOptions); err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	waitSessions(t, rest, pow_client.SessionQuery{}, 1)
+
+	cancelled, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "block", Params: []string{"a"}})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if err := cancelled.Cancel(t.Context()); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+
+	// both are listed, newest first
+	list := waitSessions(t, rest, pow_client.SessionQuery{}, 2)
+	if got := list.Sessions[0]; got.ID != cancelled.ID() || got.Status != "rejected" || got.Code != proto.CodeClientRequest || len(got.Params) != 1 {
+		t.Errorf("got session %+v, want cancelled session", got)
+	}
+	if got := list.Sessions[1]; got.ID != fulfilled.ID() || got.Status != "fulfilled" || got.Finished.Before(got.Started) {
+		t.Errorf("got session %+v, want fulfilled session", got)
+	}
+
+	// and can be filtered and paginated
+	list = waitSessions(t, rest, pow_client.SessionQuery{Call: "stderr", After: time.Now().Add(-time.Minute)}, 1)
+	if list.Sessions[0].ID != fulfilled.ID() {
+		t.Errorf("got session %q, want %q", list.Sessions[0].ID, fulfilled.ID())
+	}
+	list = waitSessions(t, rest, pow_client.SessionQuery{Limit: 1}, 1)
+	if list.Next == "" {
+		t.Fatal("got no cursor for the next page")
+	}
+	list = waitSessions(t, rest, pow_client.SessionQuery{Limit: 1, Cursor: list.Next}, 1)
+	if list.Sessions[0].ID != fulfilled.ID() || list.Next != "" {
+		t.Errorf("got session %q and cursor %q on the last page", list.Sessions[0].ID, list.Next)
+	}
+
+	// the details include output and result
+	details, err := pow_client.GetSession(t.Context(), rest, fulfilled.ID())
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if details.Output != "out" || details.Stderr != "err" || details.Result == nil || details.Result.Reason != nil {
+		t.Errorf("got details %+v, want output and fulfilled result", details)
+	}
+
+	_, err = pow_client.GetSession(t.Context(), rest, "unknown")
+	var rerr *pow_client.ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusNotFound {
+		t.Errorf("got error %v, want status %d", err, http.StatusNotFound)
+	}
+}
+
+func TestSessions_secret(t *testing.T) {
+	t.Parallel()
+
+	rest, _ := newRemotes(t, testHandler, process_over_websocket.Options{
+		RESTOptions: rest_impl.Options{History: history.Options{MaxEntries: 10}},
+	})
+
+	session, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "stderr"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if _, err := session.Wait(t.Context(), testWaitOptions); err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	// sessions protected by a secret are not listed
+	list, err := pow_client.ListSessions(t.Context(), rest, pow_client.SessionQuery{})
+	if err != nil || len(list.Sessions) != 0 {
+		t.Errorf("got sessions %v and error %v, want none", list.Sessions, err)
+	}
+
+	// and their details require the secret
+	_, err = pow_client.GetSession(t.Context(), rest, session.ID())
+	var rerr *pow_client.ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusForbidden {
+		t.Errorf("got error %v, want status %d", err, http.StatusForbidden)
+	}
+
+	withSecret := rest
+	withSecret.Header = http.Header{proto.SessionSecretHeader: []string{session.Secret()}}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		details, err := pow_client.GetSession(t.Context(), withSecret, session.ID())
+		if err == nil {
+			if details.Output != "out" {
+				t.Errorf("got output %q, want %q", details.Output, "out")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to get session: %v", err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words errors http testing time github process over websocket internal limit client proto
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	rest, ws := newRemotes(t, testHandler, process_over_websocket.Options{
+		Limits: limit.Options{Max: 1, QueueSize: 2},
+	})
+
+	// the first process runs
+	running, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	// the next ones have to wait
+	queued, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "stderr"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	status, err := queued.Status(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if status.Queued != 1 || status.Result != nil {
+		t.Errorf("got position %d and result %v, want 1 and pending", status.Queued, status.Result)
+	}
+
+	wsQueued, err := pow_client.Dial(t.Context(), ws, proto.CallMessage{Call: "stderr", Queue: true})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); wsQueued.Queued() != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got position %d, want 2", wsQueued.Queued())
+		}
+	}
+
+	// and once the queue is full, processes are rejected
+	_, err = pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "stderr"})
+	var rerr *pow_client.ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusServiceUnavailable || !errors.Is(err, proto.ErrQueueFull) {
+		t.Errorf("got error %v, want status %d", err, http.StatusServiceUnavailable)
+	}
+
+	// finishing the first process lets the others run
+	if err := running.Cancel(t.Context()); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+	status, err = queued.Wait(t.Context(), testWaitOptions)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if status.Queued != 0 || status.Buffer != "out" {
+		t.Errorf("got position %d and buffer %q, want 0 and %q", status.Queued, status.Buffer, "out")
+	}
+
+	result, err := wsQueued.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason != nil || wsQueued.Queued() != 0 {
+		t.Errorf("got reason %v and position %d, want nil and 0", result.Reason, wsQueued.Queued())
+	}
+}
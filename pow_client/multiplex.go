@@ -0,0 +1,222 @@
+//spellchecker:words client
+package pow_client
+
+//spellchecker:words context encoding json errors sync github process over websocket proto gorilla
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/gorilla/websocket"
+)
+
+// Multiplexer runs several websocket sessions over a single connection.
+// It uses the [proto.SubprotocolMultiplex] subprotocol.
+//
+// A multiplexer is created using [DialMultiplexer].
+type Multiplexer struct {
+	conn *websocket.Conn
+
+	// wm protects writing to conn
+	wm sync.Mutex
+
+	// m protects the fields below
+	m        sync.Mutex
+	next     uint64                       // id of the last channel opened
+	channels map[uint64]*WebsocketSession // open channels
+	err      error                        // set once the connection is closed
+
+	done chan struct{}
+}
+
+// DialMultiplexer connects to the websocket server at remote.
+// The context is only used for establishing the connection.
+//
+// If the server does not support multiplexing, returns [ErrMultiplexUnsupported].
+func DialMultiplexer(ctx context.Context, remote Remote) (*Multiplexer, error) {
+	conn, err := dialConn(ctx, remote, proto.SubprotocolMultiplex)
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure that the server speaks our protocol
+	if conn.Subprotocol() != proto.SubprotocolMultiplex {
+		_ = conn.Close()
+		return nil, ErrMultiplexUnsupported
+	}
+
+	mux := &Multiplexer{
+		conn:     conn,
+		channels: make(map[uint64]*WebsocketSession),
+		done:     make(chan struct{}),
+	}
+	go mux.read()
+	return mux, nil
+}
+
+// Dial opens a new channel and instructs the server to start the given call.
+// See the [Dial] function.
+func (mux *Multiplexer) Dial(call proto.CallMessage) (*WebsocketSession, error) {
+	return mux.open(call)
+}
+
+// DialQuery opens a new channel and sends the server the given query.
+// See the [DialQuery] function.
+func (mux *Multiplexer) DialQuery(query proto.Query) (*WebsocketSession, error) {
+	return mux.open(proto.QueryMessage{Query: query})
+}
+
+// Close closes the underlying connection.
+// Processes of sessions that have not yet completed are cancelled by the server.
+func (mux *Multiplexer) Close() error {
+	if err := mux.conn.Close(); err != nil {
+		return fmt.Errorf("failed to close connection: %w", err)
+	}
+	<-mux.done
+	return nil
+}
+
+var errMultiplexerClosed = errors.New("multiplexer closed")
+
+// open opens a new channel and sends the initial message.
+func (mux *Multiplexer) open(initial any) (*WebsocketSession, error) {
+	session, err := func() (*WebsocketSession, error) {
+		mux.m.Lock()
+		defer mux.m.Unlock()
+
+		if mux.err != nil {
+			return nil, fmt.Errorf("%w: %w", errMultiplexerClosed, mux.err)
+		}
+
+		mux.next++
+		session := newSession(&channel{mux: mux, id: mux.next}, initial)
+		mux.channels[mux.next] = session
+		return session, nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	// send the initial message
+	if err := session.writeJSON(initial); err != nil {
+		_ = session.Close()
+		return nil, fmt.Errorf("failed to send initial message: %w", err)
+	}
+	return session, nil
+}
+
+// read reads messages from the connection until it is closed.
+func (mux *Multiplexer) read() {
+	defer close(mux.done)
+	defer func() { _ = mux.conn.Close() }()
+
+	for {
+		_, data, err := mux.conn.ReadMessage()
+		if err != nil {
+			mux.m.Lock()
+			mux.err = err
+			channels := mux.channels
+			mux.channels = nil
+			mux.m.Unlock()
+
+			for _, session := range channels {
+				session.finish(err)
+			}
+			return
+		}
+
+		var message proto.MultiplexMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			continue
+		}
+
+		mux.m.Lock()
+		session := mux.channels[message.Channel]
+		mux.m.Unlock()
+
+		if session == nil {
+			continue
+		}
+
+		var result bool
+		if message.Text != nil {
+			result = session.handle(websocket.TextMessage, []byte(*message.Text))
+		} else {
+			result = session.handle(websocket.BinaryMessage, message.Message)
+		}
+
+		// the server closes the channel after sending the result
+		if result {
+			mux.remove(message.Channel)
+			session.finish(nil)
+		}
+	}
+}
+
+// remove removes the channel with the given id.
+func (mux *Multiplexer) remove(id uint64) {
+	mux.m.Lock()
+	defer mux.m.Unlock()
+
+	delete(mux.channels, id)
+}
+
+// write writes a frame to the channel with the given id.
+func (mux *Multiplexer) write(id uint64, tp int, data []byte) error {
+	message := proto.MultiplexMessage{Channel: id}
+	if tp == websocket.TextMessage {
+		text := string(data)
+		message.Text = &text
+	} else {
+		message.Message = data
+	}
+
+	frame, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	mux.wm.Lock()
+	defer mux.wm.Unlock()
+
+	if err := mux.conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+// channel is a channel of a [Multiplexer].
+// It implements [transport].
+type channel struct {
+	mux *Multiplexer
+	id  uint64
+}
+
+func (ch *channel) WriteMessage(messageType int, data []byte) error {
+	return ch.mux.write(ch.id, messageType, data)
+}
+
+var errChannelClosed = errors.New("channel closed")
+
+// Close requests cancellation of the process, and stops receiving messages for this channel.
+func (ch *channel) Close() error {
+	ch.mux.m.Lock()
+	session := ch.mux.channels[ch.id]
+	delete(ch.mux.channels, ch.id)
+	ch.mux.m.Unlock()
+
+	// already closed
+	if session == nil {
+		return nil
+	}
+	session.finish(errChannelClosed)
+
+	data, err := json.Marshal(proto.SignalMessage{Signal: proto.SignalCancel})
+	if err != nil {
+		return fmt.Errorf("failed to marshal signal: %w", err)
+	}
+	return ch.mux.write(ch.id, websocket.BinaryMessage, data)
+}
@@ -0,0 +1,133 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words encoding json strconv sync testing github process over websocket client proto
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+func TestMultiplexer(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testRegistry, process_over_websocket.Options{})
+	mux, err := pow_client.DialMultiplexer(t.Context(), remote)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer func() { _ = mux.Close() }()
+
+	// run several processes concurrently
+	const count = 10
+
+	var wg sync.WaitGroup
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			want := strconv.Itoa(i) + "\n"
+
+			session, err := mux.Dial(proto.CallMessage{Call: "echo", Params: []string{strconv.Itoa(i)}})
+			if err != nil {
+				t.Errorf("failed to dial: %v", err)
+				return
+			}
+
+			input := session.Input()
+			if _, err := io.WriteString(input, want); err != nil {
+				t.Errorf("failed to write input: %v", err)
+			}
+			if err := input.Close(); err != nil {
+				t.Errorf("failed to close input: %v", err)
+			}
+
+			output, err := io.ReadAll(session.Output())
+			if err != nil {
+				t.Errorf("failed to read output: %v", err)
+			}
+			if got := string(output); got != want {
+				t.Errorf("got output %q, want %q", got, want)
+			}
+
+			result, err := session.Wait(t.Context())
+			if err != nil {
+				t.Errorf("failed to wait: %v", err)
+				return
+			}
+			if result.Reason != nil {
+				t.Errorf("got reason %v, want nil", result.Reason)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// queries work as well
+	session, err := mux.DialQuery(proto.QueryProcesses)
+	if err != nil {
+		t.Fatalf("failed to dial query: %v", err)
+	}
+
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	var processes []proto.ProcessInfo
+	if err := json.Unmarshal(result.Value.(json.RawMessage), &processes); err != nil {
+		t.Fatalf("failed to decode value: %v", err)
+	}
+	if len(processes) != 1 || processes[0].Name != "echo" {
+		t.Errorf("got processes %v, want only echo", processes)
+	}
+}
+
+func TestMultiplexer_cancel(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+	mux, err := pow_client.DialMultiplexer(t.Context(), remote)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer func() { _ = mux.Close() }()
+
+	blocked, err := mux.Dial(proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	// cancelling one process does not affect the others
+	if err := blocked.Cancel(); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+	result, err := blocked.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason == nil {
+		t.Errorf("got reason nil, want error")
+	}
+
+	session, err := mux.Dial(proto.CallMessage{Call: "echo"})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if err := session.CloseInput(); err != nil {
+		t.Fatalf("failed to close input: %v", err)
+	}
+	result, err = session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason != nil {
+		t.Errorf("got reason %v, want nil", result.Reason)
+	}
+}
@@ -0,0 +1,99 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words errors http strings testing github process over websocket internal origin client proto
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/internal/origin"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+const (
+	allowedOrigin = "https://frontend.example"
+	otherOrigin   = "https://other.example"
+)
+
+func TestOrigins(t *testing.T) {
+	t.Parallel()
+
+	rest, ws := newRemotes(t, testHandler, process_over_websocket.Options{
+		Origins: origin.Options{AllowedOrigins: []string{allowedOrigin}},
+	})
+
+	// requests from other origins are rejected
+	rest.Header = http.Header{"Origin": []string{otherOrigin}}
+	_, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "stderr"})
+	var rerr *pow_client.ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusForbidden {
+		t.Errorf("got error %v, want status %d", err, http.StatusForbidden)
+	}
+	ws.Header = rest.Header
+	if _, err := pow_client.Dial(t.Context(), ws, proto.CallMessage{Call: "stderr"}); err == nil {
+		t.Error("websocket connection from other origin succeeded")
+	}
+
+	// requests from the same and allowed origins succeed
+	for _, o := range []string{strings.TrimSuffix(rest.URL, "/"), allowedOrigin} {
+		rest.Header = http.Header{"Origin": []string{o}}
+		session, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "stderr"})
+		if err != nil {
+			t.Errorf("%s: failed to start: %v", o, err)
+		} else if session.Secret() == "" {
+			t.Errorf("%s: did not receive a secret", o)
+		}
+
+		ws.Header = rest.Header
+		wsSession, err := pow_client.Dial(t.Context(), ws, proto.CallMessage{Call: "stderr"})
+		if err != nil {
+			t.Errorf("%s: failed to dial: %v", o, err)
+			continue
+		}
+		if _, err := wsSession.Wait(t.Context()); err != nil {
+			t.Errorf("%s: failed to wait: %v", o, err)
+		}
+	}
+}
+
+func TestOrigins_preflight(t *testing.T) {
+	t.Parallel()
+
+	rest, _ := newRemotes(t, testHandler, process_over_websocket.Options{
+		Origins: origin.Options{AllowedOrigins: []string{allowedOrigin}},
+	})
+
+	for _, tt := range []struct {
+		origin string
+		status int
+		allow  string
+	}{
+		{allowedOrigin, http.StatusNoContent, allowedOrigin},
+		{otherOrigin, http.StatusForbidden, ""},
+	} {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodOptions, rest.URL+"new", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		_ = res.Body.Close()
+
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.origin, res.StatusCode, tt.status)
+		}
+		if got := res.Header.Get("Access-Control-Allow-Origin"); got != tt.allow {
+			t.Errorf("%s: got allowed origin %q, want %q", tt.origin, got, tt.allow)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Package pow_client implements clients for the process_over_websocket protocol.
+//
+//spellchecker:words client
+package pow_client
+
+//spellchecker:words http
+import (
+	"errors"
+	"net/http"
+)
+
+// Remote specifies a remote endpoint for either protocol to connect to.
+type Remote struct {
+	// URL is the url of the remote server.
+	// Websocket sessions expect a "ws://" or "wss://" url.
+	URL string
+
+	// Header holds additional headers to send along with each request.
+	Header http.Header
+
+	// Client is the client used to make requests in REST sessions.
+	// If nil, uses [http.DefaultClient].
+	Client *http.Client
+}
+
+var (
+	// ErrNoResult indicates that the connection closed before a result was received.
+	ErrNoResult = errors.New("connection closed before receiving result")
+
+	// ErrWaitExceeded indicates that waiting for a session exceeded the maximum wait time.
+	ErrWaitExceeded = errors.New("wait time limit exceeded")
+
+	// ErrNotResumable indicates that a session can not be resumed.
+	ErrNotResumable = errors.New("session is not resumable")
+
+	// ErrMultiplexUnsupported indicates that the server does not support running several sessions over a single connection.
+	ErrMultiplexUnsupported = errors.New("server does not support multiplexing")
+)
@@ -0,0 +1,64 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words encoding json errors http testing github process over websocket internal quota client proto
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/internal/quota"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	rest, ws := newRemotes(t, testHandler, process_over_websocket.Options{
+		Quota: quota.Options{StartsPerMinute: 1, Burst: 2},
+	})
+
+	// use up the quota on both transports
+	if _, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "stderr"}); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	session, err := pow_client.Dial(t.Context(), ws, proto.CallMessage{Call: "stderr"})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if result, err := session.Wait(t.Context()); err != nil || result.Reason != nil {
+		t.Fatalf("got result %v and error %v, want success", result, err)
+	}
+
+	// further processes are rejected
+	_, err = pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "stderr"})
+	var rerr *pow_client.ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusTooManyRequests || !errors.Is(err, proto.ErrRateLimited) {
+		t.Errorf("got error %v, want status %d", err, http.StatusTooManyRequests)
+	} else if rerr.RetryAfter <= 0 {
+		t.Errorf("got retry after %s, want positive", rerr.RetryAfter)
+	}
+
+	session, err = pow_client.Dial(t.Context(), ws, proto.CallMessage{Call: "stderr"})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	var reason proto.ResultError
+	if !errors.As(result.Reason, &reason) || reason.Code != proto.CodeRateLimited {
+		t.Fatalf("got reason %v, want code %q", result.Reason, proto.CodeRateLimited)
+	}
+	var details struct {
+		RetryAfter int `json:"retryAfter"`
+	}
+	if err := json.Unmarshal(reason.Details, &details); err != nil || details.RetryAfter <= 0 {
+		t.Errorf("got details %s, want positive retryAfter", reason.Details)
+	}
+}
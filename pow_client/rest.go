@@ -0,0 +1,327 @@
+//spellchecker:words client
+package pow_client
+
+//spellchecker:words bytes context encoding json errors http strconv strings time github process over websocket proto
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Status is the status of a process returned by the REST API.
+// A nil Result indicates that the process is still pending.
+type Status = proto.Status
+
+// RestSession is a process_over_websocket session via the REST-based protocol.
+//
+// A session is created using [Start].
+type RestSession struct {
+	remote Remote
+	id     string
+	secret string
+}
+
+// Start instructs the REST server at remote to start the given call.
+func Start(ctx context.Context, remote Remote, call proto.CallMessage) (*RestSession, error) {
+	session := &RestSession{remote: remote}
+
+	body, err := json.Marshal(call)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal call message: %w", err)
+	}
+
+	header, err := restHeader(ctx, remote, "new", "application/json", body, &session.id)
+	if err != nil {
+		return nil, err
+	}
+	if session.id == "" {
+		return nil, errNoID
+	}
+
+	// pass the secret along with all further requests
+	session.secret = header.Get(proto.SessionSecretHeader)
+	if session.secret != "" {
+		session.remote.Header = session.remote.Header.Clone()
+		if session.remote.Header == nil {
+			session.remote.Header = make(http.Header)
+		}
+		session.remote.Header.Set(proto.SessionSecretHeader, session.secret)
+	}
+	return session, nil
+}
+
+var errNoID = errors.New("did not receive an id")
+
+// ID returns the id of this session.
+func (session *RestSession) ID() string {
+	return session.id
+}
+
+// Secret returns the secret of this session, or the empty string if the server did not send one.
+// It is sent along with all requests of this session, and may be used to attach to it, see [proto.AttachMessage].
+func (session *RestSession) Secret() string {
+	return session.secret
+}
+
+// Status fetches the current status of the session from the server.
+func (session *RestSession) Status(ctx context.Context) (status Status, err error) {
+	return session.StatusSince(ctx, 0, 0)
+}
+
+// StatusSince is like Status, but only fetches lines of output and error output newer than the given cursors.
+// Cursors are typically taken from the Cursor and StderrCursor fields of a previous status.
+func (session *RestSession) StatusSince(ctx context.Context, since, stderrSince uint64) (status Status, err error) {
+	query := url.Values{}
+	if since != 0 {
+		query.Set("since", strconv.FormatUint(since, 10))
+	}
+	if stderrSince != 0 {
+		query.Set("stderrSince", strconv.FormatUint(stderrSince, 10))
+	}
+
+	path := "status/" + url.PathEscape(session.id)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	err = rest(ctx, session.remote, path, "", nil, &status)
+	return
+}
+
+// WaitOptions are options for [RestSession.Wait].
+type WaitOptions struct {
+	// PollInterval is the interval between subsequent status requests.
+	// Defaults to 500 milliseconds.
+	PollInterval time.Duration
+
+	// MaxWait is the maximum time to wait for the process to complete.
+	// Defaults to one hour.
+	MaxWait time.Duration
+}
+
+const (
+	defaultPollInterval = 500 * time.Millisecond
+	defaultMaxWait      = time.Hour
+)
+
+// SetDefaults sets defaults for the options.
+func (opt *WaitOptions) SetDefaults() {
+	if opt.PollInterval <= 0 {
+		opt.PollInterval = defaultPollInterval
+	}
+	if opt.MaxWait <= 0 {
+		opt.MaxWait = defaultMaxWait
+	}
+}
+
+// Wait repeatedly polls the status of the session until the process has completed.
+//
+// If the process does not complete within the maximum wait time, returns [ErrWaitExceeded].
+// If the context is closed before the process completes, returns the context's error.
+func (session *RestSession) Wait(ctx context.Context, options WaitOptions) (Status, error) {
+	options.SetDefaults()
+
+	ctx, cancel := context.WithTimeoutCause(ctx, options.MaxWait, ErrWaitExceeded)
+	defer cancel()
+
+	ticker := time.NewTicker(options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := session.Status(ctx)
+		if err != nil {
+			if errors.Is(context.Cause(ctx), ErrWaitExceeded) {
+				return Status{}, ErrWaitExceeded
+			}
+			return Status{}, err
+		}
+		if status.Result != nil {
+			return status, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return Status{}, context.Cause(ctx)
+		}
+	}
+}
+
+// SendInput sends input to the process.
+func (session *RestSession) SendInput(ctx context.Context, data []byte) error {
+	return rest(ctx, session.remote, "input/"+url.PathEscape(session.id), "text/plain", data, nil)
+}
+
+// CloseInput closes the input of the process.
+func (session *RestSession) CloseInput(ctx context.Context) error {
+	return rest(ctx, session.remote, "closeInput/"+url.PathEscape(session.id), "text/plain", []byte{}, nil)
+}
+
+// Cancel requests cancellation of the process.
+func (session *RestSession) Cancel(ctx context.Context) error {
+	return rest(ctx, session.remote, "cancel/"+url.PathEscape(session.id), "text/plain", []byte{}, nil)
+}
+
+// ResponseError is returned when the server responds with an unexpected status code.
+type ResponseError struct {
+	StatusCode int
+	Message    string
+
+	// Reason is set if the server responded with a rejected result, see [proto.ResultError].
+	Reason error
+
+	// RetryAfter is the time after which the server asked the client to try again, if any.
+	RetryAfter time.Duration
+}
+
+func (re *ResponseError) Error() string {
+	return fmt.Sprintf("server returned status %d: %s", re.StatusCode, re.Message)
+}
+
+// Unwrap returns the reason sent by the server, if any.
+func (re *ResponseError) Unwrap() error {
+	return re.Reason
+}
+
+// maxErrorMessage is the maximum size of an error message read from a response.
+const maxErrorMessage = 4096
+
+// ListProcesses lists the processes provided by the REST server at remote.
+func ListProcesses(ctx context.Context, remote Remote) (processes []proto.ProcessInfo, err error) {
+	err = rest(ctx, remote, "processes", "", nil, &processes)
+	return
+}
+
+// SessionInfo, SessionList and SessionDetails describe finished sessions in the history of a REST server.
+type (
+	SessionInfo    = proto.SessionInfo
+	SessionList    = proto.SessionList
+	SessionDetails = proto.SessionDetails
+)
+
+// SessionQuery selects sessions in the history of a REST server, see [ListSessions].
+// Zero values select sessions regardless of the respective field.
+type SessionQuery struct {
+	Status string // "fulfilled" or "rejected"
+	Call   string // name of the process
+
+	After, Before time.Time // time the session started
+
+	Cursor string // [SessionList.Next] of a previous query
+	Limit  int    // maximal number of sessions returned
+}
+
+// values encodes this query as url query parameters.
+func (query SessionQuery) values() url.Values {
+	values := make(url.Values)
+	for name, value := range map[string]string{"status": query.Status, "call": query.Call, "cursor": query.Cursor} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	if !query.After.IsZero() {
+		values.Set("after", query.After.Format(time.RFC3339Nano))
+	}
+	if !query.Before.IsZero() {
+		values.Set("before", query.Before.Format(time.RFC3339Nano))
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	return values
+}
+
+// ListSessions lists finished sessions in the history of the REST server at remote, newest first.
+func ListSessions(ctx context.Context, remote Remote, query SessionQuery) (list SessionList, err error) {
+	path := "sessions"
+	if values := query.values(); len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+	err = rest(ctx, remote, path, "", nil, &list)
+	return
+}
+
+// GetSession returns the details of the finished session with the given id from the history of the REST server at remote.
+// If the server requires session secrets, the secret must be passed in the header of remote, see [proto.SessionSecretHeader].
+func GetSession(ctx context.Context, remote Remote, id string) (details SessionDetails, err error) {
+	err = rest(ctx, remote, "sessions/"+url.PathEscape(id), "", nil, &details)
+	return
+}
+
+// rest sends a request to the given path of remote.
+// If body is nil, a GET request is sent, otherwise a POST request with the given content type.
+// If result is not nil, the response is decoded as json into result.
+func rest(ctx context.Context, remote Remote, path string, contentType string, body []byte, result any) error {
+	_, err := restHeader(ctx, remote, path, contentType, body, result)
+	return err
+}
+
+// restHeader is like rest, but also returns the header of the response.
+func restHeader(ctx context.Context, remote Remote, path string, contentType string, body []byte, result any) (http.Header, error) {
+	method := http.MethodGet
+	var reader io.Reader
+	if body != nil {
+		method = http.MethodPost
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, buildURL(remote, path), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range remote.Header {
+		req.Header[key] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	client := remote.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorMessage))
+		rerr := &ResponseError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(message))}
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			rerr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+
+		var rejected proto.Result
+		if json.Unmarshal(message, &rejected) == nil && rejected.Reason != nil {
+			rerr.Message = rejected.Reason.Error()
+			rerr.Reason = rejected.Reason
+		}
+		return nil, rerr
+	}
+
+	if result == nil {
+		return res.Header, nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return res.Header, nil
+}
+
+// buildURL builds the url for the given path of remote.
+func buildURL(remote Remote, path string) string {
+	return strings.TrimSuffix(remote.URL, "/") + "/" + strings.TrimPrefix(path, "/")
+}
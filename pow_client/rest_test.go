@@ -0,0 +1,201 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words encoding json errors http strings testing time github process over websocket proto client
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+var testWaitOptions = pow_client.WaitOptions{PollInterval: 10 * time.Millisecond, MaxWait: 5 * time.Second}
+
+func TestRestSession_echo(t *testing.T) {
+	t.Parallel()
+
+	remote, _ := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Start(t.Context(), remote, proto.CallMessage{Call: "echo", Params: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	if err := session.SendInput(t.Context(), []byte("hello\n")); err != nil {
+		t.Fatalf("failed to send input: %v", err)
+	}
+	if err := session.CloseInput(t.Context()); err != nil {
+		t.Fatalf("failed to close input: %v", err)
+	}
+
+	status, err := session.Wait(t.Context(), testWaitOptions)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if status.Buffer != "hello" {
+		t.Errorf("got buffer %q, want %q", status.Buffer, "hello")
+	}
+	if status.Result.Reason != nil {
+		t.Fatalf("got reason %v, want nil", status.Result.Reason)
+	}
+
+	var value []string
+	if err := json.Unmarshal(status.Result.Value.(json.RawMessage), &value); err != nil {
+		t.Fatalf("failed to decode value: %v", err)
+	}
+	if len(value) != 2 || value[0] != "a" || value[1] != "b" {
+		t.Errorf("got value %v, want [a b]", value)
+	}
+}
+
+func TestStart_rejected(t *testing.T) {
+	t.Parallel()
+
+	remote, _ := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	for _, tt := range []struct {
+		call   string
+		status int
+		err    error
+	}{
+		{"unknown", http.StatusNotFound, proto.ErrHandlerUnknownProcess},
+		{"invalid", http.StatusBadRequest, proto.ErrHandlerInvalidArgs},
+		{"denied", http.StatusForbidden, proto.ErrHandlerAuthorizationDenied},
+	} {
+		_, err := pow_client.Start(t.Context(), remote, proto.CallMessage{Call: tt.call})
+
+		var rerr *pow_client.ResponseError
+		if !errors.As(err, &rerr) || rerr.StatusCode != tt.status {
+			t.Errorf("%s: got error %v, want status %d", tt.call, err, tt.status)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want to match %v", tt.call, err, tt.err)
+		}
+	}
+}
+
+func TestRestSession_StatusSince(t *testing.T) {
+	t.Parallel()
+
+	remote, _ := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Start(t.Context(), remote, proto.CallMessage{Call: "echo"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	var cursor uint64
+	for _, line := range []string{"first", "second"} {
+		if err := session.SendInput(t.Context(), []byte(line+"\n")); err != nil {
+			t.Fatalf("failed to send input: %v", err)
+		}
+
+		// wait for the line to be echoed
+		status := pow_client.Status{Cursor: cursor}
+		for status.Cursor == cursor {
+			status, err = session.StatusSince(t.Context(), cursor, 0)
+			if err != nil {
+				t.Fatalf("failed to get status: %v", err)
+			}
+		}
+
+		if status.Buffer != line || status.Cursor != cursor+1 || status.Dropped != 0 {
+			t.Errorf("got buffer %q, cursor %d, dropped %d; want %q, %d, 0", status.Buffer, status.Cursor, status.Dropped, line, cursor+1)
+		}
+		cursor = status.Cursor
+	}
+}
+
+func TestRestSession_stderr(t *testing.T) {
+	t.Parallel()
+
+	remote, _ := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Start(t.Context(), remote, proto.CallMessage{Call: "stderr"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	status, err := session.Wait(t.Context(), testWaitOptions)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if status.Buffer != "out" || status.Stderr != "err" {
+		t.Errorf("got buffer %q and stderr %q, want %q and %q", status.Buffer, status.Stderr, "out", "err")
+	}
+}
+
+func TestRestSession_cancel(t *testing.T) {
+	t.Parallel()
+
+	remote, _ := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Start(t.Context(), remote, proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	status, err := session.Status(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if status.Result != nil {
+		t.Fatalf("got result %v, want pending", status.Result)
+	}
+
+	if err := session.Cancel(t.Context()); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+
+	status, err = session.Wait(t.Context(), testWaitOptions)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	var reason proto.ResultError
+	if !errors.As(status.Result.Reason, &reason) || !strings.Contains(reason.Message, proto.ErrCancelClientRequest.Error()) {
+		t.Errorf("got reason %v, want to contain %q", status.Result.Reason, proto.ErrCancelClientRequest)
+	}
+}
+
+func TestRestSession_waitExceeded(t *testing.T) {
+	t.Parallel()
+
+	remote, _ := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Start(t.Context(), remote, proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	_, err = session.Wait(t.Context(), pow_client.WaitOptions{PollInterval: 10 * time.Millisecond, MaxWait: 100 * time.Millisecond})
+	if !errors.Is(err, pow_client.ErrWaitExceeded) {
+		t.Errorf("got error %v, want %v", err, pow_client.ErrWaitExceeded)
+	}
+}
+
+func TestListProcesses(t *testing.T) {
+	t.Parallel()
+
+	remote, _ := newRemotes(t, testRegistry, process_over_websocket.Options{})
+	processes, err := pow_client.ListProcesses(t.Context(), remote)
+	if err != nil {
+		t.Fatalf("failed to list processes: %v", err)
+	}
+	if len(processes) != 1 || processes[0].Name != "echo" || !processes[0].Input {
+		t.Errorf("got processes %v, want only echo", processes)
+	}
+
+	remote, _ = newRemotes(t, testHandler, process_over_websocket.Options{})
+	_, err = pow_client.ListProcesses(t.Context(), remote)
+	var re *pow_client.ResponseError
+	if !errors.As(err, &re) || re.StatusCode != http.StatusNotFound {
+		t.Errorf("got error %v, want status %d", err, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,33 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words httptest strings testing github process over websocket client proto
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// newRemotes starts a new server using handler and the given options for testing.
+// It returns remotes for its REST and websocket endpoints.
+func newRemotes(t *testing.T, handler proto.Handler, options process_over_websocket.Options) (rest, ws pow_client.Remote) {
+	t.Helper()
+
+	server := &process_over_websocket.Server{
+		Handler: handler,
+		Options: options,
+	}
+	server.Options.RESTOptions.DisableSwaggerUI = true
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+
+	return pow_client.Remote{URL: httpServer.URL + "/"}, pow_client.Remote{URL: "ws" + strings.TrimPrefix(httpServer.URL, "http")}
+}
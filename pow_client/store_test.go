@@ -0,0 +1,82 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words errors http httptest sync atomic testing github process over websocket internal rest impl client proto store
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket/internal/rest_impl"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/store"
+)
+
+func TestStore_restart(t *testing.T) {
+	t.Parallel()
+
+	dir, err := store.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	options := rest_impl.Options{DisableSwaggerUI: true, Store: dir}
+
+	// serve whichever server is current, so that clients keep using the same url across restarts
+	var current atomic.Pointer[rest_impl.Server]
+	current.Store(rest_impl.NewServer("/", testHandler, options))
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current.Load().ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		current.Load().Close()
+		httpServer.Close()
+	})
+	remote := pow_client.Remote{URL: httpServer.URL}
+
+	// run one process to completion, and leave another one running
+	finished, err := pow_client.Start(t.Context(), remote, proto.CallMessage{Call: "stderr"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if _, err := finished.Wait(t.Context(), testWaitOptions); err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	running, err := pow_client.Start(t.Context(), remote, proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	// restart the server
+	current.Swap(rest_impl.NewServer("/", testHandler, options)).Close()
+
+	// the finished process is still available
+	status, err := finished.Status(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if status.Result == nil || status.Result.Reason != nil || status.Buffer != "out" || status.Stderr != "err" {
+		t.Errorf("got status %+v, want fulfilled with output", status)
+	}
+
+	// the running process was interrupted
+	status, err = running.Status(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if status.Result == nil || !errors.Is(status.Result.Reason, proto.ErrServerRestarted) {
+		t.Errorf("got result %v, want %v", status.Result, proto.ErrServerRestarted)
+	}
+
+	// and restored sessions still require their secret
+	res, err := http.Get(httpServer.URL + "/status/" + finished.ID()) //nolint:noctx
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("got status code %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+}
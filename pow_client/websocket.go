@@ -0,0 +1,463 @@
+//spellchecker:words client
+package pow_client
+
+//spellchecker:words context encoding json sync github process over websocket proto gorilla
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/gorilla/websocket"
+)
+
+// WebsocketSession is a process_over_websocket session via the websocket-based protocol.
+//
+// A session is created using [Dial], [DialQuery] or [Attach], or using a [Multiplexer].
+// Output of the process must be consumed using [WebsocketSession.Output] and [WebsocketSession.Stderr]
+// or it will be held in memory until the session is garbage collected.
+type WebsocketSession struct {
+	conn transport
+
+	// wm protects writing to conn and inputClosed
+	wm          sync.Mutex
+	inputClosed bool
+
+	output *outputBuffer
+	stderr *outputBuffer
+
+	// rm protects token, sequence and queued
+	rm       sync.Mutex
+	token    string // token for resuming the session, if any
+	sequence uint64 // sequence number of the last numbered frame received
+	queued   int    // position of the process in the queue, if it is waiting to start
+
+	// credits is the number of initial credits, if flow control was enabled in the initial message
+	credits uint64
+
+	// queue is set if the position in the queue was requested in the initial message
+	queue bool
+
+	// am protects the fields below, and is used by ackCond
+	am       sync.Mutex
+	ackCond  *sync.Cond
+	sent     uint64 // number of text frames sent
+	acked    uint64 // number of text frames acknowledged by the server
+	finished bool   // set once the session has finished
+
+	// done is closed once the connection has been closed.
+	// Afterwards result and err are populated.
+	done       chan struct{}
+	finishOnce sync.Once
+	result     *proto.Result
+	err        error
+}
+
+// transport sends the frames of a session.
+// It is implemented by [*websocket.Conn], and by [*channel] for sessions of a [Multiplexer].
+type transport interface {
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// newSession creates a new session using the given transport.
+// initial is the initial message that will be sent.
+func newSession(conn transport, initial any) *WebsocketSession {
+	session := &WebsocketSession{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	session.output = newOutputBuffer(session.grant)
+	session.stderr = newOutputBuffer(session.grant)
+	session.ackCond = sync.NewCond(&session.am)
+
+	switch initial := initial.(type) {
+	case proto.CallMessage:
+		session.credits, session.queue = initial.Credits, initial.Queue
+	case proto.AttachMessage:
+		session.credits, session.queue = initial.Credits, initial.Queue
+	case proto.ResumeMessage:
+		session.credits, session.queue = initial.Credits, initial.Queue
+	}
+	return session
+}
+
+// Dial connects to the websocket server at remote and instructs it to start the given call.
+// The context is only used for establishing the connection.
+func Dial(ctx context.Context, remote Remote, call proto.CallMessage) (*WebsocketSession, error) {
+	return dial(ctx, remote, call)
+}
+
+// DialQuery connects to the websocket server at remote and sends it the given query.
+// The answer to the query is returned as the value of the result.
+func DialQuery(ctx context.Context, remote Remote, query proto.Query) (*WebsocketSession, error) {
+	return dial(ctx, remote, proto.QueryMessage{Query: query})
+}
+
+// Attach connects to the websocket server at remote and attaches to a process previously started using the REST API.
+// See [RestSession.ID] for obtaining the id of such a process.
+//
+// Output of the process that is still buffered by the server is replayed before any new output.
+// Closing the session without cancelling leaves the process running.
+func Attach(ctx context.Context, remote Remote, attach proto.AttachMessage) (*WebsocketSession, error) {
+	return dial(ctx, remote, attach)
+}
+
+// Token returns the token that can be used to resume this session.
+// It is empty unless a resumable session was requested in the call, and the server supports resumable sessions.
+func (session *WebsocketSession) Token() string {
+	session.rm.Lock()
+	defer session.rm.Unlock()
+
+	return session.token
+}
+
+// Resume connects to the websocket server at remote and resumes this session after its connection was lost.
+// The returned session continues with the output following the output received by this session.
+// If flow control was enabled, it is enabled for the returned session using the same initial credits.
+// Similarly, the position in the queue is requested if it was requested for this session.
+//
+// If the session is not resumable, returns [ErrNotResumable].
+func (session *WebsocketSession) Resume(ctx context.Context, remote Remote) (*WebsocketSession, error) {
+	session.rm.Lock()
+	resume := proto.ResumeMessage{Resume: session.token, Since: session.sequence, Credits: session.credits, Queue: session.queue}
+	session.rm.Unlock()
+
+	if resume.Resume == "" {
+		return nil, ErrNotResumable
+	}
+
+	resumed, err := dial(ctx, remote, resume)
+	if err != nil {
+		return nil, err
+	}
+
+	resumed.rm.Lock()
+	defer resumed.rm.Unlock()
+
+	resumed.token = resume.Resume
+	resumed.sequence = resume.Since
+
+	return resumed, nil
+}
+
+// dial connects to the websocket server and sends the initial message.
+func dial(ctx context.Context, remote Remote, initial any) (*WebsocketSession, error) {
+	conn, err := dialConn(ctx, remote, proto.Subprotocol)
+	if err != nil {
+		return nil, err
+	}
+
+	// ensure that the server speaks our protocol
+	if conn.Subprotocol() != proto.Subprotocol {
+		_ = conn.Close()
+		return nil, proto.ErrWrongSubprotocol
+	}
+
+	// send the initial message
+	session := newSession(conn, initial)
+	if err := session.writeJSON(initial); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to send initial message: %w", err)
+	}
+
+	go session.read(conn)
+	return session, nil
+}
+
+// dialConn connects to the websocket server at remote using the given subprotocol.
+func dialConn(ctx context.Context, remote Remote, subprotocol string) (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{subprotocol}
+
+	conn, _, err := dialer.DialContext(ctx, remote.URL, remote.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+	return conn, nil
+}
+
+// read reads messages from the connection until it is closed.
+func (session *WebsocketSession) read(conn *websocket.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	for {
+		tp, data, err := conn.ReadMessage()
+		if err != nil {
+			session.finish(err)
+			return
+		}
+		session.handle(tp, data)
+	}
+}
+
+// handle handles a message received from the server.
+// It returns true if the message was the result.
+func (session *WebsocketSession) handle(tp int, data []byte) (result bool) {
+	switch tp {
+	case websocket.TextMessage:
+		session.received("")
+		_, _ = session.output.Write(data)
+	case websocket.BinaryMessage:
+		var message struct {
+			Stderr *string `json:"stderr"`
+			Token  *string `json:"token"`
+			Ack    *uint64 `json:"ack"`
+			Queued *int    `json:"queued"`
+		}
+		_ = json.Unmarshal(data, &message)
+
+		// input acknowledgement
+		if message.Ack != nil {
+			session.acknowledged(*message.Ack)
+			return false
+		}
+
+		// position in the queue
+		if message.Queued != nil {
+			session.rm.Lock()
+			session.queued = *message.Queued
+			session.rm.Unlock()
+			return false
+		}
+
+		// error output
+		if message.Stderr != nil {
+			session.received("")
+			_, _ = session.stderr.Write([]byte(*message.Stderr))
+			return false
+		}
+
+		// token for resuming
+		if message.Token != nil {
+			session.received(*message.Token)
+			return false
+		}
+
+		// result
+		var result proto.Result
+		if err := json.Unmarshal(data, &result); err != nil {
+			session.err = fmt.Errorf("failed to decode result message: %w", err)
+			return false
+		}
+		session.result = &result
+		return true
+	}
+	return false
+}
+
+// finish marks the session as finished, because of the given cause.
+// Unless a result was received, Wait returns an error wrapping [ErrNoResult] and cause.
+func (session *WebsocketSession) finish(cause error) {
+	session.finishOnce.Do(func() {
+		if session.result == nil && session.err == nil {
+			session.err = fmt.Errorf("%w: %w", ErrNoResult, cause)
+		}
+		session.output.CloseWithError(nil)
+		session.stderr.CloseWithError(nil)
+
+		session.am.Lock()
+		session.finished = true
+		session.ackCond.Broadcast()
+		session.am.Unlock()
+
+		close(session.done)
+	})
+}
+
+// acknowledged records that the server has acknowledged the given total number of text frames.
+func (session *WebsocketSession) acknowledged(acked uint64) {
+	session.am.Lock()
+	defer session.am.Unlock()
+
+	if acked > session.acked {
+		session.acked = acked
+		session.ackCond.Broadcast()
+	}
+}
+
+// grant grants the server credits for the given number of frames, if flow control is enabled.
+// It is called once frames of output have been consumed.
+func (session *WebsocketSession) grant(frames uint64) {
+	if session.credits == 0 {
+		return
+	}
+	_ = session.writeJSON(proto.CreditMessage{Credit: frames})
+}
+
+// received records that a message was received.
+// If token is empty, the message was a numbered frame.
+// Otherwise, it was a token message holding the given token.
+func (session *WebsocketSession) received(token string) {
+	session.rm.Lock()
+	defer session.rm.Unlock()
+
+	if token != "" {
+		session.token = token
+		return
+	}
+	session.sequence++
+}
+
+// Queued returns the position of the process in the queue of the server, starting at 1.
+// It returns 0 if the process is not waiting for other processes to finish.
+// The position is only known if it was requested in the call, see [proto.CallMessage].
+func (session *WebsocketSession) Queued() int {
+	session.rm.Lock()
+	defer session.rm.Unlock()
+
+	return session.queued
+}
+
+// Output returns a reader that reads the output of the process.
+// It returns [io.EOF] once the connection has been closed and all output was read.
+//
+// If flow control was enabled, the server is granted a credit for each frame of output that has been read.
+func (session *WebsocketSession) Output() io.Reader {
+	return session.output
+}
+
+// Stderr returns a reader that reads the error output of the process.
+// Error output is only sent separately if requested in the call, see [proto.CallMessage].
+// It returns [io.EOF] once the connection has been closed and all error output was read.
+func (session *WebsocketSession) Stderr() io.Reader {
+	return session.stderr
+}
+
+// Input returns a writer that sends input to the process.
+// Closing the writer closes the input of the process.
+//
+// If flow control was enabled, writes block while [proto.InputWindow] writes have not yet been acknowledged.
+func (session *WebsocketSession) Input() io.WriteCloser {
+	return sessionInput{session: session}
+}
+
+type sessionInput struct {
+	session *WebsocketSession
+}
+
+func (si sessionInput) Write(data []byte) (int, error) {
+	if err := si.session.writeText(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (si sessionInput) Close() error {
+	return si.session.CloseInput()
+}
+
+// CloseInput closes the input of the process.
+// Any further input is ignored.
+func (session *WebsocketSession) CloseInput() error {
+	session.wm.Lock()
+	defer session.wm.Unlock()
+
+	if session.inputClosed {
+		return nil
+	}
+	session.inputClosed = true
+
+	return session.writeSignal(proto.SignalClose)
+}
+
+// Cancel requests cancellation of the process.
+func (session *WebsocketSession) Cancel() error {
+	session.wm.Lock()
+	defer session.wm.Unlock()
+
+	return session.writeSignal(proto.SignalCancel)
+}
+
+// Wait waits for the process to complete and returns the result sent by the server.
+//
+// If the connection closes without a result, returns an error wrapping [ErrNoResult].
+// If the context is closed before the process completes, returns the context's error.
+func (session *WebsocketSession) Wait(ctx context.Context) (*proto.Result, error) {
+	select {
+	case <-session.done:
+		if session.result != nil {
+			return session.result, nil
+		}
+		return nil, session.err
+	case <-ctx.Done():
+		//nolint:wrapcheck
+		return nil, ctx.Err()
+	}
+}
+
+// Close forcibly closes the underlying connection.
+// If the process has not yet completed, it is cancelled by the server.
+// Resumable sessions are only cancelled once the grace period of the server has passed, see [WebsocketSession.Resume].
+//
+// For sessions of a [Multiplexer], only the channel of the session is closed after requesting cancellation.
+func (session *WebsocketSession) Close() error {
+	if err := session.conn.Close(); err != nil {
+		return fmt.Errorf("failed to close connection: %w", err)
+	}
+	return nil
+}
+
+func (session *WebsocketSession) writeText(data []byte) error {
+	if session.credits > 0 {
+		if err := session.reserve(); err != nil {
+			return err
+		}
+	}
+
+	session.wm.Lock()
+	defer session.wm.Unlock()
+
+	if session.inputClosed {
+		return io.ErrClosedPipe
+	}
+	return session.writeMessage(websocket.TextMessage, data)
+}
+
+// reserve waits until the input window permits sending another text frame, and then reserves it.
+func (session *WebsocketSession) reserve() error {
+	session.am.Lock()
+	defer session.am.Unlock()
+
+	for session.sent-session.acked >= proto.InputWindow && !session.finished {
+		session.ackCond.Wait()
+	}
+	if session.finished {
+		return io.ErrClosedPipe
+	}
+	session.sent++
+	return nil
+}
+
+// writeSignal writes the given signal.
+// session.wm must be held.
+func (session *WebsocketSession) writeSignal(signal proto.Signal) error {
+	data, err := json.Marshal(proto.SignalMessage{Signal: signal})
+	if err != nil {
+		return fmt.Errorf("failed to marshal signal: %w", err)
+	}
+	return session.writeMessage(websocket.BinaryMessage, data)
+}
+
+func (session *WebsocketSession) writeJSON(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	session.wm.Lock()
+	defer session.wm.Unlock()
+
+	return session.writeMessage(websocket.BinaryMessage, data)
+}
+
+// writeMessage writes a message of the given type to the connection.
+// session.wm must be held.
+func (session *WebsocketSession) writeMessage(tp int, data []byte) error {
+	if err := session.conn.WriteMessage(tp, data); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,582 @@
+//spellchecker:words client
+package pow_client_test
+
+//spellchecker:words context encoding json errors http strings testing time github process over websocket proto client registry
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket"
+	"github.com/FAU-CDI/process_over_websocket/pow_client"
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/registry"
+)
+
+// testHandler provides processes used by the tests.
+var testHandler = proto.HandlerFunc(func(r *http.Request, name string, args ...string) (proto.Process, error) {
+	switch name {
+	case "echo":
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			if _, err := io.Copy(output, input); err != nil {
+				return nil, fmt.Errorf("failed to copy: %w", err)
+			}
+			return args, nil
+		}), nil
+	case "stderr":
+		return proto.StderrProcessFunc(func(ctx context.Context, input io.Reader, output, stderr io.Writer, args ...string) (any, error) {
+			_, _ = io.WriteString(output, "out\n")
+			_, _ = io.WriteString(stderr, "err\n")
+			return nil, nil
+		}), nil
+	case "lines":
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			for i := range 5 {
+				if _, err := fmt.Fprintf(output, "line %d\n", i); err != nil {
+					return nil, fmt.Errorf("failed to write: %w", err)
+				}
+			}
+			return nil, nil
+		}), nil
+	case "coded":
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			return nil, fmt.Errorf("process failed: %w", codedError{})
+		}), nil
+	case "block":
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			<-ctx.Done()
+			return nil, context.Cause(ctx)
+		}), nil
+	case "whoami":
+		if proto.PrincipalFrom(r.Context()) == nil {
+			return nil, proto.ErrHandlerAuthorizationDenied
+		}
+		return proto.ProcessFunc(func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+			return proto.PrincipalFrom(ctx).Name, nil
+		}), nil
+	case "denied":
+		return nil, proto.ErrHandlerAuthorizationDenied
+	case "invalid":
+		return nil, fmt.Errorf("%w: %d arguments", proto.ErrHandlerInvalidArgs, len(args))
+	}
+	return nil, proto.ErrHandlerUnknownProcess
+})
+
+// codedError is an error providing its own code and details.
+type codedError struct{}
+
+func (codedError) Error() string              { return "coded error" }
+func (codedError) ErrorCode() proto.ErrorCode { return "coded" }
+func (codedError) ErrorDetails() any          { return map[string]int{"answer": 42} }
+
+// testRegistry is a handler that provides a list of processes.
+var testRegistry = func() *registry.Registry {
+	var reg registry.Registry
+	reg.RegisterFunc("echo", registry.Info{Description: "echoes input", Variadic: true, Input: true}, func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+		_, err := io.Copy(output, input)
+		return args, err
+	})
+	return &reg
+}()
+
+func TestWebsocketSession_echo(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "echo", Params: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	input := session.Input()
+	if _, err := io.WriteString(input, "hello\n"); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	if err := input.Close(); err != nil {
+		t.Fatalf("failed to close input: %v", err)
+	}
+
+	output, err := io.ReadAll(session.Output())
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if got := string(output); got != "hello\n" {
+		t.Errorf("got output %q, want %q", got, "hello\n")
+	}
+
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason != nil {
+		t.Fatalf("got reason %v, want nil", result.Reason)
+	}
+
+	var value []string
+	if err := json.Unmarshal(result.Value.(json.RawMessage), &value); err != nil {
+		t.Fatalf("failed to decode value: %v", err)
+	}
+	if len(value) != 2 || value[0] != "a" || value[1] != "b" {
+		t.Errorf("got value %v, want [a b]", value)
+	}
+}
+
+func TestWebsocketSession_stderr(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	for _, separate := range []bool{false, true} {
+		session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "stderr", Stderr: separate})
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		var output, stderr []byte
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			stderr, _ = io.ReadAll(session.Stderr())
+		}()
+		output, _ = io.ReadAll(session.Output())
+		<-done
+
+		wantOutput, wantStderr := "out\nerr\n", ""
+		if separate {
+			wantOutput, wantStderr = "out\n", "err\n"
+		}
+		if string(output) != wantOutput || string(stderr) != wantStderr {
+			t.Errorf("separate=%t: got output %q and stderr %q, want %q and %q", separate, output, stderr, wantOutput, wantStderr)
+		}
+	}
+}
+
+func TestWebsocketSession_flowControl(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	t.Run("output", func(t *testing.T) {
+		t.Parallel()
+
+		session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "lines", Credits: 2})
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		// without reading output, the process runs out of credits
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := session.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+
+		// reading the output grants more credits
+		output, err := io.ReadAll(session.Output())
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		if want := "line 0\nline 1\nline 2\nline 3\nline 4\n"; string(output) != want {
+			t.Errorf("got output %q, want %q", output, want)
+		}
+
+		result, err := session.Wait(t.Context())
+		if err != nil {
+			t.Fatalf("failed to wait: %v", err)
+		}
+		if result.Reason != nil {
+			t.Errorf("got reason %v, want nil", result.Reason)
+		}
+	})
+
+	t.Run("input", func(t *testing.T) {
+		t.Parallel()
+
+		session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "echo", Credits: 1})
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		// write more input than fits into the input window
+		var want strings.Builder
+		go func() {
+			input := session.Input()
+			for i := range 10 * proto.InputWindow {
+				_, _ = fmt.Fprintf(input, "%d\n", i)
+			}
+			_ = input.Close()
+		}()
+		for i := range 10 * proto.InputWindow {
+			_, _ = fmt.Fprintf(&want, "%d\n", i)
+		}
+
+		output, err := io.ReadAll(session.Output())
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		if string(output) != want.String() {
+			t.Errorf("got output %q, want %q", output, want.String())
+		}
+
+		result, err := session.Wait(t.Context())
+		if err != nil {
+			t.Fatalf("failed to wait: %v", err)
+		}
+		if result.Reason != nil {
+			t.Errorf("got reason %v, want nil", result.Reason)
+		}
+	})
+}
+
+func TestWebsocketSession_cancel(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	if err := session.Cancel(); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := session.Wait(ctx)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	var reason proto.ResultError
+	if !errors.As(result.Reason, &reason) || !strings.Contains(reason.Message, proto.ErrCancelClientRequest.Error()) {
+		t.Errorf("got reason %v, want to contain %q", result.Reason, proto.ErrCancelClientRequest)
+	}
+}
+
+func TestWebsocketSession_unknown(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "unknown"})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason == nil || !strings.Contains(result.Reason.Error(), proto.ErrHandlerUnknownProcess.Error()) {
+		t.Errorf("got reason %v, want to contain %q", result.Reason, proto.ErrHandlerUnknownProcess)
+	}
+	if !errors.Is(result.Reason, proto.ErrHandlerUnknownProcess) {
+		t.Errorf("got reason %v, want to match %v", result.Reason, proto.ErrHandlerUnknownProcess)
+	}
+}
+
+func TestWebsocketSession_errorCode(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "coded"})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	var reason proto.ResultError
+	if !errors.As(result.Reason, &reason) {
+		t.Fatalf("got reason %v, want a proto.ResultError", result.Reason)
+	}
+	if want := "process returned error: process failed: coded error"; reason.Message != want {
+		t.Errorf("got message %q, want %q", reason.Message, want)
+	}
+	if reason.Code != "coded" {
+		t.Errorf("got code %q, want %q", reason.Code, "coded")
+	}
+	if want := `{"answer":42}`; string(reason.Details) != want {
+		t.Errorf("got details %s, want %s", reason.Details, want)
+	}
+}
+
+func TestDialQuery_processes(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testRegistry, process_over_websocket.Options{})
+
+	session, err := pow_client.DialQuery(t.Context(), remote, proto.QueryProcesses)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason != nil {
+		t.Fatalf("got reason %v, want nil", result.Reason)
+	}
+
+	var processes []proto.ProcessInfo
+	if err := json.Unmarshal(result.Value.(json.RawMessage), &processes); err != nil {
+		t.Fatalf("failed to decode value: %v", err)
+	}
+	if len(processes) != 1 || processes[0].Name != "echo" {
+		t.Errorf("got processes %v, want only echo", processes)
+	}
+}
+
+func TestDialQuery_unsupported(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.DialQuery(t.Context(), remote, proto.QueryProcesses)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason == nil || result.Reason.Error() != proto.ErrQueryUnsupported.Error() {
+		t.Errorf("got reason %v, want %v", result.Reason, proto.ErrQueryUnsupported)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	t.Parallel()
+
+	rest, ws := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	started, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "echo", Params: []string{"a"}})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	if err := started.SendInput(t.Context(), []byte("before\n")); err != nil {
+		t.Fatalf("failed to send input: %v", err)
+	}
+
+	session, err := pow_client.Attach(t.Context(), ws, proto.AttachMessage{Attach: started.ID(), Secret: started.Secret()})
+	if err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+
+	input := session.Input()
+	if _, err := io.WriteString(input, "after\n"); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	if err := input.Close(); err != nil {
+		t.Fatalf("failed to close input: %v", err)
+	}
+
+	output, err := io.ReadAll(session.Output())
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if got := string(output); got != "before\nafter\n" {
+		t.Errorf("got output %q, want %q", got, "before\nafter\n")
+	}
+
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason != nil {
+		t.Errorf("got reason %v, want nil", result.Reason)
+	}
+}
+
+func TestAttach_cancel(t *testing.T) {
+	t.Parallel()
+
+	rest, ws := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	started, err := pow_client.Start(t.Context(), rest, proto.CallMessage{Call: "block"})
+	if err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	// detaching leaves the process running
+	session, err := pow_client.Attach(t.Context(), ws, proto.AttachMessage{Attach: started.ID(), Secret: started.Secret()})
+	if err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	status, err := started.Status(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if status.Result != nil {
+		t.Fatalf("got result %v, want pending", status.Result)
+	}
+
+	// cancelling cancels the process
+	session, err = pow_client.Attach(t.Context(), ws, proto.AttachMessage{Attach: started.ID(), Secret: started.Secret()})
+	if err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+	if err := session.Cancel(); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+
+	status, err = started.Wait(t.Context(), testWaitOptions)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if status.Result.Reason == nil || !strings.Contains(status.Result.Reason.Error(), proto.ErrCancelClientRequest.Error()) {
+		t.Errorf("got reason %v, want to contain %q", status.Result.Reason, proto.ErrCancelClientRequest)
+	}
+}
+
+func TestAttach_unknown(t *testing.T) {
+	t.Parallel()
+
+	_, ws := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Attach(t.Context(), ws, proto.AttachMessage{Attach: "unknown"})
+	if err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+
+	result, err := session.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason == nil {
+		t.Errorf("got reason nil, want error")
+	}
+}
+
+func TestWebsocketSession_Resume(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{ResumeGracePeriod: time.Minute})
+
+	session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "echo", Resumable: true})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	// receive some output, then lose the connection
+	if _, err := io.WriteString(session.Input(), "first\n"); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	first := make([]byte, len("first\n"))
+	if _, err := io.ReadFull(session.Output(), first); err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if _, err := session.Wait(t.Context()); !errors.Is(err, pow_client.ErrNoResult) {
+		t.Fatalf("got error %v, want %v", err, pow_client.ErrNoResult)
+	}
+
+	// resume and finish the process
+	resumed, err := session.Resume(t.Context(), remote)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+
+	input := resumed.Input()
+	if _, err := io.WriteString(input, "second\n"); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	if err := input.Close(); err != nil {
+		t.Fatalf("failed to close input: %v", err)
+	}
+
+	output, err := io.ReadAll(resumed.Output())
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if got := string(output); got != "second\n" {
+		t.Errorf("got output %q, want %q", got, "second\n")
+	}
+
+	result, err := resumed.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason != nil {
+		t.Errorf("got reason %v, want nil", result.Reason)
+	}
+}
+
+func TestWebsocketSession_Resume_expired(t *testing.T) {
+	t.Parallel()
+
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{ResumeGracePeriod: 100 * time.Millisecond})
+
+	session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "block", Resumable: true})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	// wait for the token
+	for session.Token() == "" {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	_, _ = session.Wait(t.Context())
+
+	time.Sleep(time.Second)
+
+	resumed, err := session.Resume(t.Context(), remote)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+
+	result, err := resumed.Wait(t.Context())
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if result.Reason == nil {
+		t.Errorf("got reason nil, want error")
+	}
+}
+
+func TestWebsocketSession_Resume_notResumable(t *testing.T) {
+	t.Parallel()
+
+	// the server does not support resumable sessions
+	_, remote := newRemotes(t, testHandler, process_over_websocket.Options{})
+
+	session, err := pow_client.Dial(t.Context(), remote, proto.CallMessage{Call: "echo", Resumable: true})
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if err := session.CloseInput(); err != nil {
+		t.Fatalf("failed to close input: %v", err)
+	}
+	if _, err := session.Wait(t.Context()); err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	if _, err := session.Resume(t.Context(), remote); !errors.Is(err, pow_client.ErrNotResumable) {
+		t.Errorf("got error %v, want %v", err, pow_client.ErrNotResumable)
+	}
+}
@@ -0,0 +1,54 @@
+//spellchecker:words proto
+package proto
+
+//spellchecker:words context errors http
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Principal identifies an authenticated client.
+type Principal struct {
+	// Name uniquely identifies the principal.
+	Name string
+
+	// Roles are the roles or groups the principal belongs to.
+	// They may be used by handlers to decide which processes the principal may run.
+	Roles []string
+}
+
+// Authenticator resolves the principal making a request.
+//
+// Servers call Authenticate for every request before handling it.
+// Requests that fail authentication are rejected without reaching the [Handler].
+type Authenticator interface {
+	// Authenticate returns the principal making the request.
+	// If the request is not authenticated, it returns an error wrapping [ErrUnauthenticated].
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Challenger may optionally be implemented by an [Authenticator] to tell clients how to authenticate.
+type Challenger interface {
+	// Challenge returns the value of the WWW-Authenticate header sent along with rejected requests.
+	Challenge() string
+}
+
+// ErrUnauthenticated indicates that a request could not be authenticated.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx holding the given principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal held by ctx, or nil if there is none.
+//
+// Servers using an [Authenticator] provide the principal to the [Handler] via the context of the request,
+// and to the [Process] via the context passed to it.
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
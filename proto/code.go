@@ -0,0 +1,128 @@
+//spellchecker:words proto
+package proto
+
+//spellchecker:words encoding json errors
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrorCode is a stable, machine-readable code describing why a process was rejected.
+// It is sent along with the reason of a rejected [Result].
+type ErrorCode string
+
+// Error codes derived from the errors in this package, see [CodeOf].
+const (
+	CodeUnknownProcess      ErrorCode = "unknown_process"      // [ErrHandlerUnknownProcess]
+	CodeInvalidArgs         ErrorCode = "invalid_args"         // [ErrHandlerInvalidArgs]
+	CodeAuthorizationDenied ErrorCode = "authorization_denied" // [ErrHandlerAuthorizationDenied]
+	CodeUnauthenticated     ErrorCode = "unauthenticated"      // [ErrUnauthenticated]
+	CodeQueueFull           ErrorCode = "queue_full"           // [ErrQueueFull]
+	CodeRateLimited         ErrorCode = "rate_limited"         // [ErrRateLimited]
+	CodeServerRestarted     ErrorCode = "server_restarted"     // [ErrServerRestarted]
+
+	CodeClientGone    ErrorCode = "client_gone"    // [ErrCancelClientGone]
+	CodeHandlerReturn ErrorCode = "handler_return" // [ErrCancelHandlerReturn]
+	CodeClientRequest ErrorCode = "client_request" // [ErrCancelClientRequest]
+	CodeProtocolError ErrorCode = "protocol_error" // [ErrCancelProtocolError]
+	CodeTimeout       ErrorCode = "timeout"        // [ErrCancelTimeout]
+
+	CodeQueryUnsupported ErrorCode = "query_unsupported" // [ErrQueryUnsupported]
+
+	// CodeError is used for any error without a more specific code.
+	CodeError ErrorCode = "error"
+)
+
+// codes maps errors to their codes, in order of precedence.
+var codes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrHandlerUnknownProcess, CodeUnknownProcess},
+	{ErrHandlerInvalidArgs, CodeInvalidArgs},
+	{ErrHandlerAuthorizationDenied, CodeAuthorizationDenied},
+	{ErrUnauthenticated, CodeUnauthenticated},
+	{ErrQueueFull, CodeQueueFull},
+	{ErrRateLimited, CodeRateLimited},
+	{ErrServerRestarted, CodeServerRestarted},
+
+	{ErrCancelClientGone, CodeClientGone},
+	{ErrCancelHandlerReturn, CodeHandlerReturn},
+	{ErrCancelClientRequest, CodeClientRequest},
+	{ErrCancelProtocolError, CodeProtocolError},
+	{ErrCancelTimeout, CodeTimeout},
+
+	{ErrQueryUnsupported, CodeQueryUnsupported},
+}
+
+// CodedError may be implemented by errors returned from a [Process] or [Handler] to provide their own code and details.
+type CodedError interface {
+	error
+
+	// ErrorCode returns the code of this error.
+	ErrorCode() ErrorCode
+
+	// ErrorDetails returns structured details about this error, or nil.
+	// Details are marshaled as json.
+	ErrorDetails() any
+}
+
+// CodeOf returns the code and details for the given error.
+//
+// If err, or any error it wraps, implements [CodedError], its code and details are used.
+// Otherwise, the code is derived from the errors in this package, falling back to [CodeError].
+// If err is nil, returns an empty code.
+func CodeOf(err error) (code ErrorCode, details any) {
+	if err == nil {
+		return "", nil
+	}
+
+	var coded CodedError
+	if errors.As(err, &coded) {
+		if code := coded.ErrorCode(); code != "" {
+			return code, coded.ErrorDetails()
+		}
+	}
+
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code, nil
+		}
+	}
+	return CodeError, nil
+}
+
+// ResultError is the error type used for the Reason of a [Result] decoded from json.
+// It holds the message, code and details sent by the remote end.
+//
+// It implements [CodedError], and matches the errors in this package corresponding to its code using [errors.Is].
+type ResultError struct {
+	Message string
+	Code    ErrorCode       // empty if the remote end did not send a code
+	Details json.RawMessage // nil if the remote end did not send details
+}
+
+func (re ResultError) Error() string {
+	return re.Message
+}
+
+func (re ResultError) ErrorCode() ErrorCode {
+	return re.Code
+}
+
+func (re ResultError) ErrorDetails() any {
+	if re.Details == nil {
+		return nil
+	}
+	return re.Details
+}
+
+// Is reports if target is the error in this package corresponding to the code of this error.
+func (re ResultError) Is(target error) bool {
+	for _, c := range codes {
+		if c.err == target { //nolint:errorlint // target is a sentinel error
+			return c.code == re.Code
+		}
+	}
+	return false
+}
@@ -26,10 +26,51 @@ func (hf HandlerFunc) Get(r *http.Request, name string, args ...string) (Process
 	return hf(r, name, args...)
 }
 
+// ProcessLister may optionally be implemented by a [Handler] to enumerate the processes it provides.
+type ProcessLister interface {
+	// Processes returns information about the processes available to the given request.
+	//
+	// The request is the request that the client sent to ask for the processes.
+	Processes(r *http.Request) []ProcessInfo
+}
+
+// ProcessInfo holds information about a process provided by a handler.
+type ProcessInfo struct {
+	// Name is the name used to call the process.
+	Name string `json:"name"`
+
+	// Description is a human-readable description of the process.
+	Description string `json:"description,omitempty"`
+
+	// Params describes the parameters expected by the process.
+	Params []ParamInfo `json:"params,omitempty"`
+
+	// Variadic indicates that the process accepts arbitrarily many parameters beyond Params.
+	Variadic bool `json:"variadic,omitempty"`
+
+	// Input indicates if the process reads from its input.
+	Input bool `json:"input,omitempty"`
+}
+
+// ParamInfo holds information about a single parameter of a process.
+type ParamInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
 var (
 	ErrHandlerUnknownProcess      = errors.New("unknown process")
 	ErrHandlerInvalidArgs         = errors.New("invalid args")
 	ErrHandlerAuthorizationDenied = errors.New("authorization denied")
+
+	// ErrQueueFull indicates that a process was rejected because too many processes are already running or waiting.
+	ErrQueueFull = errors.New("too many processes waiting")
+
+	// ErrRateLimited indicates that a process was rejected because the client has exceeded its quota.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrServerRestarted indicates that a process was interrupted because the server restarted while it was running.
+	ErrServerRestarted = errors.New("server restarted")
 )
 
 // Process represents a process handled by the protocol.
@@ -51,6 +92,36 @@ func (pf ProcessFunc) Do(ctx context.Context, input io.Reader, output io.Writer,
 	return pf(ctx, input, output, args...)
 }
 
+// StderrProcess may optionally be implemented by a [Process] to write error output separately from regular output.
+type StderrProcess interface {
+	Process
+
+	// DoStderr is like Do, but receives a separate writer for error output.
+	//
+	// Servers call DoStderr instead of Do when a process implements this interface.
+	DoStderr(ctx context.Context, input io.Reader, output, stderr io.Writer, args ...string) (any, error)
+}
+
+// StderrProcessFunc implements StderrProcess.
+type StderrProcessFunc func(ctx context.Context, input io.Reader, output, stderr io.Writer, args ...string) (any, error)
+
+func (spf StderrProcessFunc) Do(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+	return spf(ctx, input, output, output, args...)
+}
+
+func (spf StderrProcessFunc) DoStderr(ctx context.Context, input io.Reader, output, stderr io.Writer, args ...string) (any, error) {
+	return spf(ctx, input, output, stderr, args...)
+}
+
+// Do runs the given process.
+// If the process implements [StderrProcess], error output is written to stderr, otherwise it is not used.
+func Do(ctx context.Context, process Process, input io.Reader, output, stderr io.Writer, args ...string) (any, error) {
+	if sp, ok := process.(StderrProcess); ok {
+		return sp.DoStderr(ctx, input, output, stderr, args...)
+	}
+	return process.Do(ctx, input, output, args...)
+}
+
 var (
 	// ErrCancelClientGone indicates that the client has gone away.
 	// Typically this indicates that the websocket connection has died, or that the timeout failed to ping the server without a specific time frame.
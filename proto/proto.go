@@ -6,6 +6,7 @@ package proto
 //spellchecker:words encoding json
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
@@ -13,6 +14,137 @@ import (
 type CallMessage struct {
 	Call   string   `json:"call"`
 	Params []string `json:"params,omitempty"`
+
+	// Stderr requests error output of the process to be sent separately using [StderrMessage]s.
+	// If false, error output is sent along with regular output.
+	Stderr bool `json:"stderr,omitempty"`
+
+	// Resumable requests the process to keep running for a grace period when the connection is lost.
+	// If the server supports it, it answers with a [TokenMessage] that can be used to resume the session, see [ResumeMessage].
+	Resumable bool `json:"resumable,omitempty"`
+
+	// Credits enables flow control, see [CreditMessage] and [AckMessage].
+	// It is the number of output frames the server may send before waiting for more credits.
+	// If zero, flow control is disabled.
+	Credits uint64 `json:"credits,omitempty"`
+
+	// Queue requests the position of the process in the queue to be sent using [QueuedMessage]s while it waits to start.
+	// If false, the client is not told about the position of the process.
+	Queue bool `json:"queue,omitempty"`
+
+	// Priority is the priority of the process when it has to wait for other processes to finish, see [QueuedMessage].
+	// Processes with a higher priority are started first.
+	// Servers lower priorities above those the client is permitted to use; the default is 0.
+	Priority int `json:"priority,omitempty"`
+}
+
+// CreditMessage is sent by the client to the server to grant additional output credits.
+// It is only used when flow control was enabled in the [CallMessage] or [ResumeMessage].
+//
+// Each text frame and [StderrMessage] sent by the server consumes one credit.
+// Once all credits are consumed, writing output blocks the process until the client grants more credits.
+type CreditMessage struct {
+	Credit uint64 `json:"credit"`
+}
+
+// AckMessage is sent by the server to the client to acknowledge input when flow control is enabled.
+//
+// Ack is the total number of text frames sent by the client that the process has consumed.
+// The client must not send more than [InputWindow] text frames that have not yet been acknowledged.
+// Otherwise the server cancels the process with [ErrCancelProtocolError].
+type AckMessage struct {
+	Ack uint64 `json:"ack"`
+}
+
+// InputWindow is the maximal number of unacknowledged text frames when flow control is enabled, see [AckMessage].
+// It also limits the input of each channel of a connection using [SubprotocolMultiplex], see [MultiplexMessage].
+const InputWindow = 16
+
+// QueuedMessage is sent by the server to the client while the process waits for other processes to finish before it starts.
+//
+// Queued is the position of the process in the queue, starting at 1.
+// It is sent whenever the position changes, and once more with Queued set to 0 when the process starts.
+// It is only sent if requested in the [CallMessage].
+type QueuedMessage struct {
+	Queued int `json:"queued"`
+}
+
+// StderrMessage is sent by the server to the client to transmit error output of the process.
+// It is only sent if requested in the [CallMessage].
+type StderrMessage struct {
+	Stderr string `json:"stderr"`
+}
+
+// QueryMessage may be sent by the client to the server instead of a [CallMessage].
+// It requests information about the server instead of invoking a procedure.
+type QueryMessage struct {
+	Query Query `json:"query"`
+}
+
+type Query string
+
+const (
+	// QueryProcesses requests the list of processes provided by the server.
+	// The result holds a list of [ProcessInfo].
+	QueryProcesses Query = "processes"
+)
+
+// ErrQueryUnsupported indicates that the server does not support the requested query.
+var ErrQueryUnsupported = errors.New("query not supported")
+
+// AttachMessage may be sent by the client to the server instead of a [CallMessage].
+// It attaches to a process previously started using the REST API instead of invoking a new procedure.
+//
+// The server first sends all output of the process that is still buffered, followed by any new output.
+// Input and signals are forwarded to the process as if it had been started using a [CallMessage].
+// When the process finishes, the server sends its result.
+//
+// If the client disconnects, the process continues running.
+type AttachMessage struct {
+	Attach string `json:"attach"`           // id of the process to attach to
+	Secret string `json:"secret,omitempty"` // secret of the process, see [SessionSecretHeader]
+
+	// Stderr requests error output to be sent separately, see [CallMessage].
+	Stderr bool `json:"stderr,omitempty"`
+
+	// Credits enables flow control, see [CallMessage].
+	Credits uint64 `json:"credits,omitempty"`
+
+	// Queue requests the position of the process in the queue, see [CallMessage].
+	Queue bool `json:"queue,omitempty"`
+}
+
+// SessionSecretHeader is the header holding the secret of a process started using the REST API.
+//
+// The server returns the secret along with the id of a new process.
+// Requests concerning the process may pass the secret back using the same header, or using the "secret" query parameter.
+// Servers require the secret for processes not started by an authenticated principal, unless configured otherwise.
+const SessionSecretHeader = "X-Session-Secret"
+
+// TokenMessage is sent by the server to the client as the first message of a resumable session.
+//
+// Every text frame and [StderrMessage] sent afterwards is assigned a sequence number, starting at 1.
+// Other messages are not numbered.
+type TokenMessage struct {
+	Token string `json:"token"`
+}
+
+// ResumeMessage may be sent by the client to the server instead of a [CallMessage].
+// It resumes a resumable session after the connection to the server was lost.
+//
+// The server continues sending frames with a sequence number greater than Since.
+// If these are no longer available, the server sends a rejected result instead.
+//
+// If the session was started by an authenticated principal, only the same principal may resume it.
+type ResumeMessage struct {
+	Resume string `json:"resume"` // token received in the [TokenMessage]
+	Since  uint64 `json:"since"`  // sequence number of the last frame received
+
+	// Credits enables flow control for the resumed session, see [CallMessage].
+	Credits uint64 `json:"credits,omitempty"`
+
+	// Queue requests the position of the process in the queue for the resumed session, see [CallMessage].
+	Queue bool `json:"queue,omitempty"`
 }
 
 // SignalMessage is sent from the client to the server to stop the current procedure.
@@ -30,6 +162,28 @@ const (
 // Subprotocol is the mandatory subprotocol to be used by the websocket client.
 const Subprotocol = "pow-1"
 
+// SubprotocolMultiplex is the subprotocol used to run several processes over a single websocket connection.
+// Every frame is a binary frame holding a json-encoded [MultiplexMessage].
+const SubprotocolMultiplex = "pow-2"
+
+// MultiplexMessage wraps a frame of [Subprotocol] that is sent over a connection using [SubprotocolMultiplex].
+//
+// Frames belong to channels, which are identified by a number chosen by the client.
+// Each channel behaves like a separate connection using [Subprotocol].
+// A channel is opened by sending the initial message (such as a [CallMessage]) on a channel not in use.
+// It is closed once the server has sent the result, after which its number may be reused.
+//
+// As all channels share the same connection, the input of each channel is limited to [InputWindow] text frames not yet consumed by its process, even if flow control is disabled.
+// If a client exceeds it, the server cancels the process on that channel with [ErrCancelProtocolError].
+// Clients sending a lot of input should enable flow control to know when the process has consumed it.
+type MultiplexMessage struct {
+	Channel uint64 `json:"channel"`
+
+	// Exactly one of Text and Message is set.
+	Text    *string         `json:"text,omitempty"`    // content of a text frame
+	Message json.RawMessage `json:"message,omitempty"` // content of a binary frame
+}
+
 var ErrWrongSubprotocol = fmt.Errorf("only support subprotocol %q", Subprotocol)
 
 type Result struct {
@@ -38,6 +192,7 @@ type Result struct {
 }
 
 // MarshalJSON marshals this result as a message.
+// The code and details of a rejected result are determined using [CodeOf].
 func (res *Result) MarshalJSON() ([]byte, error) {
 	if res == nil {
 		return []byte(`{"status":"pending"}`), nil
@@ -50,27 +205,87 @@ func (res *Result) MarshalJSON() ([]byte, error) {
 		data = "value"
 	}
 
-	content := (func() string {
-		defer func() {
-			_ = recover() // ignore any panic()s during the marshal
-		}()
+	// find the object to marshal
+	obj := res.Value
+	if res.Reason != nil {
+		obj = fmt.Sprint(res.Reason)
+	}
+	content := marshalOrEmpty(obj)
 
-		// find the object to marshal
-		obj := res.Value
-		if res.Reason != nil {
-			obj = fmt.Sprint(res.Reason)
+	// rejected results also include the code and details of the error
+	var extra string
+	if res.Reason != nil {
+		code, details := CodeOf(res.Reason)
+		extra = `,"code":` + marshalOrEmpty(code)
+		if details != nil {
+			if content := marshalOrEmpty(details); content != "" {
+				extra += `,"details":` + content
+			}
 		}
+	}
 
-		// format the value
-		bytes, err := json.Marshal(obj)
-		if err != nil {
-			return ""
+	if len(content) == 0 {
+		return []byte(`{"status":"` + status + `"` + extra + `}`), nil
+	}
+	return []byte(`{"status":"` + status + `","` + data + `":` + content + extra + `}`), nil
+}
+
+// marshalOrEmpty marshals obj as json, returning the empty string if this fails.
+func marshalOrEmpty(obj any) (content string) {
+	defer func() {
+		if recover() != nil { // ignore any panic()s during the marshal
+			content = ""
 		}
-		return string(bytes)
-	})()
+	}()
 
-	if len(content) == 0 {
-		return []byte(`{"status":"` + status + `"}`), nil
+	bytes, err := json.Marshal(obj)
+	if err != nil {
+		return ""
+	}
+	return string(bytes)
+}
+
+var (
+	// ErrResultPending is returned when attempting to unmarshal a pending result.
+	ErrResultPending   = errors.New("result is still pending")
+	errUnknownStatus   = errors.New("unknown result status")
+	errNoResultMessage = errors.New("result message is not an object")
+)
+
+type resultJSON struct {
+	Status  string          `json:"status"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Reason  string          `json:"reason,omitempty"`
+	Code    ErrorCode       `json:"code,omitempty"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// UnmarshalJSON unmarshals a result message.
+//
+// The Value of a fulfilled result is stored as a [json.RawMessage], or nil if the remote end omitted it.
+// The Reason of a rejected result is stored as a [ResultError].
+// Pending results cannot be unmarshaled into a result and return [ErrResultPending].
+func (res *Result) UnmarshalJSON(data []byte) error {
+	var msg resultJSON
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return fmt.Errorf("%w: %w", errNoResultMessage, err)
+	}
+
+	switch msg.Status {
+	case "fulfilled":
+		res.Reason = nil
+		res.Value = nil
+		if len(msg.Value) > 0 {
+			res.Value = msg.Value
+		}
+		return nil
+	case "rejected":
+		res.Value = nil
+		res.Reason = ResultError{Message: msg.Reason, Code: msg.Code, Details: msg.Details}
+		return nil
+	case "pending":
+		return ErrResultPending
+	default:
+		return fmt.Errorf("%w: %q", errUnknownStatus, msg.Status)
 	}
-	return []byte(`{"status":"` + status + `","` + data + `":` + content + `}`), nil
 }
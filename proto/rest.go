@@ -0,0 +1,136 @@
+//spellchecker:words proto
+package proto
+
+//spellchecker:words encoding json errors time
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Status is the status of a process started using the REST API.
+// A nil Result indicates that the process is still pending.
+//
+// Each line of output is assigned a sequence number, starting at 1.
+// The buffers only contain lines after a cursor passed by the client.
+type Status struct {
+	Buffer  string // lines of output
+	Cursor  uint64 // sequence number of the newest line of output
+	Dropped uint64 // number of lines of output after the requested cursor no longer available
+
+	Stderr        string // error output, only used by processes implementing [StderrProcess]
+	StderrCursor  uint64 // like Cursor, but for error output
+	StderrDropped uint64 // like Dropped, but for error output
+
+	Queued int // position of the process in the queue, or 0 if it is not waiting to start
+
+	Result *Result
+}
+
+type statusJSON struct {
+	Buffer  string `json:"buffer,omitempty"`
+	Cursor  uint64 `json:"cursor"`
+	Dropped uint64 `json:"dropped,omitempty"`
+
+	Stderr        string `json:"stderr,omitempty"`
+	StderrCursor  uint64 `json:"stderrCursor,omitempty"`
+	StderrDropped uint64 `json:"stderrDropped,omitempty"`
+
+	Queued int `json:"queued,omitempty"`
+
+	Result json.RawMessage `json:"result"`
+}
+
+func (status Status) MarshalJSON() ([]byte, error) {
+	data := statusJSON{
+		Buffer:  status.Buffer,
+		Cursor:  status.Cursor,
+		Dropped: status.Dropped,
+
+		Stderr:        status.Stderr,
+		StderrCursor:  status.StderrCursor,
+		StderrDropped: status.StderrDropped,
+
+		Queued: status.Queued,
+	}
+
+	var err error
+	data.Result, err = status.Result.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result as json: %w", err)
+	}
+
+	res, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return res, nil
+}
+
+// UnmarshalJSON unmarshals a status.
+// A pending result is unmarshaled into a nil Result.
+func (status *Status) UnmarshalJSON(data []byte) error {
+	var raw statusJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to unmarshal status: %w", err)
+	}
+
+	*status = Status{
+		Buffer:  raw.Buffer,
+		Cursor:  raw.Cursor,
+		Dropped: raw.Dropped,
+
+		Stderr:        raw.Stderr,
+		StderrCursor:  raw.StderrCursor,
+		StderrDropped: raw.StderrDropped,
+
+		Queued: raw.Queued,
+	}
+
+	var result Result
+	err := json.Unmarshal(raw.Result, &result)
+	switch {
+	case errors.Is(err, ErrResultPending):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+
+	status.Result = &result
+	return nil
+}
+
+// SessionInfo describes a finished session in the history of a REST server.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Call      string    `json:"call"`
+	Params    []string  `json:"params"`
+	Principal string    `json:"principal,omitempty"` // name of the principal that started the session, if any
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitzero"` // zero if the session was interrupted by a restart
+
+	Status string    `json:"status"`         // "fulfilled" or "rejected"
+	Code   ErrorCode `json:"code,omitempty"` // code of a rejected session
+}
+
+// SessionList is a page of sessions in the history of a REST server, newest first.
+type SessionList struct {
+	Sessions []SessionInfo `json:"sessions"`
+	Next     string        `json:"next,omitempty"` // cursor to retrieve the next page, empty on the last page
+}
+
+// SessionDetails holds the details of a finished session in the history of a REST server.
+//
+// Only the newest lines of output are kept in the history.
+type SessionDetails struct {
+	SessionInfo
+
+	Output        string `json:"output"`
+	OutputDropped uint64 `json:"outputDropped,omitempty"` // number of older lines of output no longer available
+
+	Stderr        string `json:"stderr,omitempty"`
+	StderrDropped uint64 `json:"stderrDropped,omitempty"` // like OutputDropped, but for error output
+
+	Result *Result `json:"result"`
+}
@@ -0,0 +1,227 @@
+// Package registry implements [Registry].
+//
+//spellchecker:words registry
+package registry
+
+//spellchecker:words maps http slices strings sync github process over websocket proto
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Separator separates the namespaces of a process name.
+const Separator = "/"
+
+// Registry is a [proto.Handler] that dispatches calls to processes registered by name.
+//
+// Names may contain namespaces separated by [Separator], e.g. "backup/create".
+// Entire namespaces can be delegated to other handlers using [Registry.Mount].
+//
+// The zero value is ready to use.
+// Registry is safe for concurrent use.
+type Registry struct {
+	m         sync.RWMutex
+	processes map[string]entry
+	mounts    map[string]proto.Handler
+}
+
+// Info holds metadata about a registered process.
+type Info struct {
+	// Description is a human-readable description of the process.
+	Description string
+
+	// Params describes the parameters expected by the process.
+	// Calls with fewer arguments are rejected with [proto.ErrHandlerInvalidArgs].
+	Params []proto.ParamInfo
+
+	// Variadic indicates that the process accepts arbitrarily many arguments beyond Params.
+	// If false, calls with more arguments than Params are rejected.
+	Variadic bool
+
+	// Input indicates if the process reads from its input.
+	Input bool
+}
+
+// Validate checks if args are valid arguments for a process with this info.
+// If not, returns an error wrapping [proto.ErrHandlerInvalidArgs].
+func (info Info) Validate(args ...string) error {
+	if len(args) < len(info.Params) {
+		return fmt.Errorf("%w: expected at least %d argument(s), got %d", proto.ErrHandlerInvalidArgs, len(info.Params), len(args))
+	}
+	if !info.Variadic && len(args) > len(info.Params) {
+		return fmt.Errorf("%w: expected at most %d argument(s), got %d", proto.ErrHandlerInvalidArgs, len(info.Params), len(args))
+	}
+	return nil
+}
+
+// ProcessInfo returns the information about a process with the given name and this info.
+func (info Info) ProcessInfo(name string) proto.ProcessInfo {
+	return proto.ProcessInfo{
+		Name:        name,
+		Description: info.Description,
+		Params:      slices.Clone(info.Params),
+		Variadic:    info.Variadic,
+		Input:       info.Input,
+	}
+}
+
+type entry struct {
+	info    Info
+	process proto.Process
+}
+
+// Register registers a process with the given name and info.
+//
+// Register panics if name is not a valid name, or if a process with the same name is already registered.
+func (reg *Registry) Register(name string, info Info, process proto.Process) {
+	if !validName(name) {
+		panic(fmt.Sprintf("registry: invalid process name %q", name))
+	}
+	if process == nil {
+		panic("registry: nil process")
+	}
+
+	reg.m.Lock()
+	defer reg.m.Unlock()
+
+	if _, ok := reg.processes[name]; ok {
+		panic(fmt.Sprintf("registry: multiple registrations for %q", name))
+	}
+	if reg.processes == nil {
+		reg.processes = make(map[string]entry)
+	}
+	reg.processes[name] = entry{info: info, process: process}
+}
+
+// RegisterFunc is like Register, but takes a function instead of a process.
+func (reg *Registry) RegisterFunc(name string, info Info, process proto.ProcessFunc) {
+	reg.Register(name, info, process)
+}
+
+// Mount delegates all calls with a name of the form prefix + [Separator] + rest to handler.
+// The handler receives only the rest of the name.
+//
+// When multiple mounted prefixes match, the longest one is used.
+// Processes registered directly take precedence over mounted handlers.
+//
+// Mount panics if prefix is not a valid name, or if a handler is already mounted at prefix.
+func (reg *Registry) Mount(prefix string, handler proto.Handler) {
+	if !validName(prefix) {
+		panic(fmt.Sprintf("registry: invalid prefix %q", prefix))
+	}
+	if handler == nil {
+		panic("registry: nil handler")
+	}
+
+	reg.m.Lock()
+	defer reg.m.Unlock()
+
+	if _, ok := reg.mounts[prefix]; ok {
+		panic(fmt.Sprintf("registry: multiple mounts for %q", prefix))
+	}
+	if reg.mounts == nil {
+		reg.mounts = make(map[string]proto.Handler)
+	}
+	reg.mounts[prefix] = handler
+}
+
+// Namespace creates a new registry and mounts it at prefix.
+func (reg *Registry) Namespace(prefix string) *Registry {
+	var sub Registry
+	reg.Mount(prefix, &sub)
+	return &sub
+}
+
+// Get implements [proto.Handler].
+func (reg *Registry) Get(r *http.Request, name string, args ...string) (proto.Process, error) {
+	entry, handler, rest, ok := reg.lookup(name)
+	switch {
+	case !ok:
+		return nil, proto.ErrHandlerUnknownProcess
+	case handler != nil:
+		//nolint:wrapcheck // mounted handlers should decide on their own errors
+		return handler.Get(r, rest, args...)
+	}
+
+	if err := entry.info.Validate(args...); err != nil {
+		return nil, err
+	}
+	return entry.process, nil
+}
+
+// Processes implements [proto.ProcessLister].
+//
+// It returns the registered processes along with the processes of mounted handlers implementing [proto.ProcessLister].
+// Processes are sorted by name.
+func (reg *Registry) Processes(r *http.Request) []proto.ProcessInfo {
+	infos, mounts := func() ([]proto.ProcessInfo, map[string]proto.Handler) {
+		reg.m.RLock()
+		defer reg.m.RUnlock()
+
+		infos := make([]proto.ProcessInfo, 0, len(reg.processes))
+		for name, entry := range reg.processes {
+			infos = append(infos, entry.info.ProcessInfo(name))
+		}
+		return infos, maps.Clone(reg.mounts)
+	}()
+
+	for prefix, handler := range mounts {
+		lister, ok := handler.(proto.ProcessLister)
+		if !ok {
+			continue
+		}
+		for _, info := range lister.Processes(r) {
+			info.Name = prefix + Separator + info.Name
+			infos = append(infos, info)
+		}
+	}
+
+	slices.SortFunc(infos, func(a, b proto.ProcessInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos
+}
+
+// lookup finds the process or mounted handler responsible for name.
+// If a mounted handler is responsible, also returns the remaining name to pass to it.
+func (reg *Registry) lookup(name string) (entry entry, handler proto.Handler, rest string, ok bool) {
+	reg.m.RLock()
+	defer reg.m.RUnlock()
+
+	if entry, ok := reg.processes[name]; ok {
+		return entry, nil, "", true
+	}
+
+	// find the longest prefix
+	for prefix := name; ; {
+		index := strings.LastIndex(prefix, Separator)
+		if index < 0 {
+			return entry, nil, "", false
+		}
+		prefix = prefix[:index]
+
+		if handler, ok := reg.mounts[prefix]; ok {
+			return entry, handler, name[index+len(Separator):], true
+		}
+	}
+}
+
+// validName checks if name is a valid name for a process or prefix.
+// Valid names are non-empty and do not contain empty namespaces.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for part := range strings.SplitSeq(name, Separator) {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+//spellchecker:words registry
+package registry_test
+
+//spellchecker:words context errors http testing github process over websocket proto registry
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/registry"
+)
+
+// named returns a process that returns the given name.
+func named(name string) proto.ProcessFunc {
+	return func(ctx context.Context, input io.Reader, output io.Writer, args ...string) (any, error) {
+		return name, nil
+	}
+}
+
+func TestRegistry_Get(t *testing.T) {
+	t.Parallel()
+
+	var reg registry.Registry
+	reg.RegisterFunc("status", registry.Info{}, named("status"))
+	reg.RegisterFunc("echo", registry.Info{Params: []proto.ParamInfo{{Name: "message"}}}, named("echo"))
+	reg.RegisterFunc("exec", registry.Info{Params: []proto.ParamInfo{{Name: "command"}}, Variadic: true}, named("exec"))
+
+	backup := reg.Namespace("backup")
+	backup.RegisterFunc("create", registry.Info{}, named("backup/create"))
+	backup.Namespace("snapshot").RegisterFunc("list", registry.Info{}, named("backup/snapshot/list"))
+
+	reg.Mount("remote", proto.HandlerFunc(func(r *http.Request, name string, args ...string) (proto.Process, error) {
+		return named("remote:" + name), nil
+	}))
+	reg.Mount("remote/special", proto.HandlerFunc(func(r *http.Request, name string, args ...string) (proto.Process, error) {
+		return named("special:" + name), nil
+	}))
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr error
+	}{
+		{name: "status", want: "status"},
+		{name: "status", args: []string{"extra"}, wantErr: proto.ErrHandlerInvalidArgs},
+		{name: "echo", args: []string{"hello"}, want: "echo"},
+		{name: "echo", wantErr: proto.ErrHandlerInvalidArgs},
+		{name: "exec", args: []string{"ls", "-l", "-a"}, want: "exec"},
+		{name: "exec", wantErr: proto.ErrHandlerInvalidArgs},
+		{name: "backup/create", want: "backup/create"},
+		{name: "backup/snapshot/list", want: "backup/snapshot/list"},
+		{name: "backup/delete", wantErr: proto.ErrHandlerUnknownProcess},
+		{name: "backup", wantErr: proto.ErrHandlerUnknownProcess},
+		{name: "remote/a/b", want: "remote:a/b"},
+		{name: "remote/special/c", want: "special:c"},
+		{name: "unknown", wantErr: proto.ErrHandlerUnknownProcess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			process, err := reg.Get(nil, tt.name, tt.args...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			got, _ := process.Do(t.Context(), nil, io.Discard)
+			if got != tt.want {
+				t.Errorf("got process %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"", "/", "a/", "/a", "a//b"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) did not panic", name)
+				}
+			}()
+
+			var reg registry.Registry
+			reg.RegisterFunc(name, registry.Info{}, named(name))
+		})
+	}
+}
+
+func TestRegistry_Processes(t *testing.T) {
+	t.Parallel()
+
+	var reg registry.Registry
+	reg.RegisterFunc("status", registry.Info{Description: "shows status"}, named("status"))
+	reg.Namespace("backup").RegisterFunc("create", registry.Info{Params: []proto.ParamInfo{{Name: "target"}}}, named("backup/create"))
+	reg.Mount("opaque", proto.HandlerFunc(func(r *http.Request, name string, args ...string) (proto.Process, error) {
+		return named(name), nil
+	}))
+
+	got := reg.Processes(nil)
+	want := []string{"backup/create", "status"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d processes, want %d", len(got), len(want))
+	}
+	for i, info := range got {
+		if info.Name != want[i] {
+			t.Errorf("got process %d = %q, want %q", i, info.Name, want[i])
+		}
+	}
+	if got[0].Params[0].Name != "target" {
+		t.Errorf("got param %q, want %q", got[0].Params[0].Name, "target")
+	}
+	if got[1].Description != "shows status" {
+		t.Errorf("got description %q, want %q", got[1].Description, "shows status")
+	}
+}
@@ -1,11 +1,16 @@
 //spellchecker:words process over websocket
 package process_over_websocket
 
-//spellchecker:words http sync github process over websocket internal rest impl proto pkglib websocketx
+//spellchecker:words http sync time github process over websocket internal coalesce limit origin quota rest impl proto pkglib websocketx
 import (
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/FAU-CDI/process_over_websocket/internal/coalesce"
+	"github.com/FAU-CDI/process_over_websocket/internal/limit"
+	"github.com/FAU-CDI/process_over_websocket/internal/origin"
+	"github.com/FAU-CDI/process_over_websocket/internal/quota"
 	"github.com/FAU-CDI/process_over_websocket/internal/rest_impl"
 	"github.com/FAU-CDI/process_over_websocket/internal/ws_impl"
 	"github.com/FAU-CDI/process_over_websocket/proto"
@@ -23,6 +28,27 @@ type Server struct {
 	rest      *rest_impl.Server
 }
 
+// Options used by [Options], re-exported from the packages implementing them.
+type (
+	// CoalesceOptions configure batching of small writes by processes, see [Options.Coalesce].
+	CoalesceOptions = coalesce.Options
+
+	// OriginOptions configure which origins may access the server, see [Options.Origins].
+	OriginOptions = origin.Options
+
+	// LimitOptions configure the number of processes running at once, see [Options.Limits].
+	LimitOptions = limit.Options
+
+	// QuotaOptions configure how many processes each client may start, see [Options.Quota].
+	QuotaOptions = quota.Options
+)
+
+// DefaultQuotaKey is the default for the Key of [QuotaOptions].
+// It identifies clients by their principal or ip address.
+func DefaultQuotaKey(r *http.Request) string {
+	return quota.DefaultKey(r)
+}
+
 type Options struct {
 	// BasePath contains the base path of this server.
 	// It defaults to the root path ("/").
@@ -32,6 +58,30 @@ type Options struct {
 	DisableWebsocket bool
 	WebsocketOptions websocketx.Options
 
+	// ResumeGracePeriod is the time a resumable websocket session keeps running after its connection is lost.
+	// Resumable sessions are disabled unless it is positive.
+	ResumeGracePeriod time.Duration
+
+	// Coalesce configures batching of small writes by processes into fewer frames.
+	// It applies to websocket sessions, and to REST sessions unless configured in RESTOptions.
+	Coalesce CoalesceOptions
+
+	// Authenticator, if non-nil, authenticates requests to the websocket server, and to the REST server unless configured in RESTOptions.
+	// The authenticated principal is available to handlers and processes, see [proto.PrincipalFrom].
+	Authenticator proto.Authenticator
+
+	// Origins configures which origins may open websocket connections, and access the REST server unless configured in RESTOptions.
+	// By default, only requests from the same origin (and requests without an Origin header) are allowed.
+	Origins OriginOptions
+
+	// Limits limits the number of processes running at once, across both the websocket and the REST server.
+	// Processes exceeding the limits wait in a queue, or are rejected if the queue is full.
+	Limits LimitOptions
+
+	// Quota limits how many processes each client may start, across both the websocket and the REST server.
+	// Clients are identified by their principal or ip address, see [QuotaOptions].
+	Quota QuotaOptions
+
 	// DisableREST can be set to entirely disable REST access.
 	DisableREST bool
 	RESTOptions rest_impl.Options
@@ -46,14 +96,42 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (server *Server) doInit() {
 	server.init.Do(func() {
 		server.handler = func() http.Handler {
+			// processes of both servers share the same limits and quotas
+			limiter := limit.New(server.Options.Limits)
+			quotas := quota.New(server.Options.Quota)
+
 			// setup the rest server if requested
 			if !server.Options.DisableREST {
-				server.rest = rest_impl.NewServer(server.Options.BasePath, server.Handler, server.Options.RESTOptions)
+				restOptions := server.Options.RESTOptions
+				if !restOptions.Session.Coalesce.Enabled() {
+					restOptions.Session.Coalesce = server.Options.Coalesce
+				}
+				if restOptions.Authenticator == nil {
+					restOptions.Authenticator = server.Options.Authenticator
+				}
+				if restOptions.Origins.AllowedOrigins == nil {
+					restOptions.Origins = server.Options.Origins
+				}
+				if restOptions.Limiter == nil {
+					restOptions.Limiter = limiter
+				}
+				if restOptions.Quota == nil {
+					restOptions.Quota = quotas
+				}
+				server.rest = rest_impl.NewServer(server.Options.BasePath, server.Handler, restOptions)
 			}
 
 			// setup the websocket handler if requested
 			if !server.Options.DisableWebsocket {
-				server.websocket = ws_impl.NewServer(server.Options.BasePath, server.Handler, server.rest, server.Options.WebsocketOptions)
+				server.websocket = ws_impl.NewServer(server.Options.BasePath, server.Handler, restSessions{server.rest}, ws_impl.Options{
+					Options:           server.Options.WebsocketOptions,
+					ResumeGracePeriod: server.Options.ResumeGracePeriod,
+					Coalesce:          server.Options.Coalesce,
+					Authenticator:     server.Options.Authenticator,
+					Origins:           server.Options.Origins,
+					Limiter:           limiter,
+					Quota:             quotas,
+				})
 			}
 
 			// nothing is enabled =>
@@ -72,6 +150,20 @@ func (server *Server) doInit() {
 	})
 }
 
+// restSessions lets websocket clients attach to sessions of the REST server.
+type restSessions struct {
+	*rest_impl.Server
+}
+
+// Session implements [ws_impl.SessionProvider].
+func (rs restSessions) Session(r *http.Request, id, secret string) (ws_impl.Session, error) {
+	session, err := rs.Server.Session(r, id, secret)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // wrapped by the websocket server
+	}
+	return session, nil
+}
+
 func (server *Server) Close() {
 	server.doInit()
 
@@ -0,0 +1,177 @@
+// Package store persists sessions of the REST API, so that they survive restarts of the server.
+//
+//spellchecker:words store
+package store
+
+//spellchecker:words encoding json errors path filepath strings time github process over websocket proto
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+)
+
+// Record is the persisted state of a single session.
+type Record struct {
+	// ID is the id of the session.
+	ID string `json:"id"`
+
+	// Call is the call that started the session.
+	Call proto.CallMessage `json:"call"`
+
+	// Owner is the name of the principal that started the session, if any.
+	Owner string `json:"owner,omitempty"`
+
+	// SecretHash is the SHA-256 hash of the secret of the session.
+	SecretHash []byte `json:"secretHash,omitempty"`
+
+	// Started is the time the session was started.
+	// Finished is the time its process returned, or the zero time if it has not.
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+
+	// Output and Stderr hold the newest lines of output and error output.
+	Output Lines `json:"output"`
+	Stderr Lines `json:"stderr"`
+
+	// Result is the result of the process.
+	// It is nil if the process had not finished when the record was saved.
+	Result *proto.Result `json:"result,omitempty"`
+}
+
+// Lines are the newest lines written to a buffer.
+type Lines struct {
+	Lines []string `json:"lines,omitempty"`
+	Total uint64   `json:"total,omitempty"` // total number of lines written, i.e. the sequence number of the newest line
+}
+
+// Store persists records of sessions.
+//
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Save stores record, replacing any record with the same id.
+	Save(record *Record) error
+
+	// Delete removes the record with the given id.
+	// Deleting a record that does not exist is not an error.
+	Delete(id string) error
+
+	// List returns all stored records, in no particular order.
+	// If some records can not be read, the remaining ones are returned along with an error.
+	List() ([]*Record, error)
+}
+
+// Dir is a [Store] holding each record in a json file within a directory.
+type Dir struct {
+	path string
+}
+
+// NewDir returns a store that holds records in the directory at path, creating it if necessary.
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	return &Dir{path: path}, nil
+}
+
+const recordExt = ".json"
+
+var errInvalidID = errors.New("invalid record id")
+
+// filename returns the name of the file holding the record with the given id.
+func (dir *Dir) filename(id string) (string, error) {
+	if id == "" || strings.ContainsFunc(id, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_'
+	}) {
+		return "", fmt.Errorf("%w: %q", errInvalidID, id)
+	}
+	return filepath.Join(dir.path, id+recordExt), nil
+}
+
+// Save writes record to a temporary file and then renames it, so that readers never see a partially written record.
+func (dir *Dir) Save(record *Record) error {
+	name, err := dir.filename(record.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir.path, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }() // no-op once renamed
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close record: %w", err)
+	}
+	if err := os.Rename(file.Name(), name); err != nil {
+		return fmt.Errorf("failed to rename record: %w", err)
+	}
+	return nil
+}
+
+func (dir *Dir) Delete(id string) error {
+	name, err := dir.filename(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete record: %w", err)
+	}
+	return nil
+}
+
+func (dir *Dir) List() ([]*Record, error) {
+	entries, err := os.ReadDir(dir.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store directory: %w", err)
+	}
+
+	var (
+		records []*Record
+		errs    []error
+	)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || filepath.Ext(name) != recordExt {
+			continue
+		}
+
+		record, err := readRecord(filepath.Join(dir.path, name))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, errors.Join(errs...)
+}
+
+// readRecord reads the record stored in the given file.
+func readRecord(name string) (*Record, error) {
+	data, err := os.ReadFile(name) // #nosec G304 -- name is within the store directory
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record: %w", err)
+	}
+
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal record %q: %w", filepath.Base(name), err)
+	}
+	return &record, nil
+}
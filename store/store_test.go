@@ -0,0 +1,99 @@
+//spellchecker:words store
+package store_test
+
+//spellchecker:words errors path filepath testing time github process over websocket proto store
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FAU-CDI/process_over_websocket/proto"
+	"github.com/FAU-CDI/process_over_websocket/store"
+)
+
+func TestDir(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sessions")
+	dir, err := store.NewDir(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	running := &store.Record{
+		ID:      "running",
+		Call:    proto.CallMessage{Call: "block"},
+		Started: started,
+	}
+	finished := &store.Record{
+		ID:       "finished",
+		Call:     proto.CallMessage{Call: "echo", Params: []string{"a"}},
+		Owner:    "alice",
+		Started:  started,
+		Finished: started.Add(time.Second),
+		Output:   store.Lines{Lines: []string{"a"}, Total: 1},
+		Result:   &proto.Result{Reason: proto.ErrCancelClientRequest},
+	}
+	for _, record := range []*store.Record{running, finished} {
+		if err := dir.Save(record); err != nil {
+			t.Fatalf("failed to save %q: %v", record.ID, err)
+		}
+	}
+
+	// records can be replaced
+	running.Output = store.Lines{Lines: []string{"line"}, Total: 3}
+	if err := dir.Save(running); err != nil {
+		t.Fatalf("failed to save again: %v", err)
+	}
+
+	// invalid ids are rejected
+	if err := dir.Save(&store.Record{ID: "../escape"}); err == nil {
+		t.Error("saved record with invalid id")
+	}
+
+	// unreadable records are skipped
+	if err := os.WriteFile(filepath.Join(path, "broken.json"), []byte("{"), 0o600); err != nil {
+		t.Fatalf("failed to write broken record: %v", err)
+	}
+
+	records, err := dir.List()
+	if err == nil {
+		t.Error("got no error for broken record")
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	got := make(map[string]*store.Record, len(records))
+	for _, record := range records {
+		got[record.ID] = record
+	}
+	if record := got["running"]; record == nil || record.Result != nil || record.Output.Total != 3 || !record.Started.Equal(started) {
+		t.Errorf("got running record %+v", record)
+	}
+
+	record := got["finished"]
+	if record == nil || record.Owner != "alice" || len(record.Call.Params) != 1 || !record.Finished.Equal(finished.Finished) {
+		t.Fatalf("got finished record %+v", record)
+	}
+	if record.Result == nil || !errors.Is(record.Result.Reason, proto.ErrCancelClientRequest) {
+		t.Errorf("got result %v, want %v", record.Result, proto.ErrCancelClientRequest)
+	}
+
+	// deleting removes records, and is not an error for missing ones
+	for range 2 {
+		if err := dir.Delete("finished"); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if err := os.Remove(filepath.Join(path, "broken.json")); err != nil {
+		t.Fatalf("failed to remove broken record: %v", err)
+	}
+	records, err = dir.List()
+	if err != nil || len(records) != 1 || records[0].ID != "running" {
+		t.Errorf("got records %v and error %v, want only running", records, err)
+	}
+}